@@ -4,7 +4,10 @@ package agent
 import (
 	"errors"
 	"fmt"
+	"net"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/hashicorp/consul/api"
 	"github.com/newrelic/infra-integrations-sdk/v3/data/metric"
@@ -12,6 +15,7 @@ import (
 	"github.com/newrelic/infra-integrations-sdk/v3/log"
 	"github.com/newrelic/nri-consul/src/args"
 	"github.com/newrelic/nri-consul/src/metrics"
+	"github.com/newrelic/nri-consul/src/permissions"
 )
 
 // number of workers there can be per pool
@@ -25,10 +29,21 @@ type Agent struct {
 	Client     *api.Client
 	datacenter string
 	ipAddr     string
+	port       string
 	name       string
+	// clusterName is the CLUSTER_NAME argument, empty if it isn't set
+	clusterName string
+
+	telemetryOnce sync.Once
+	telemetry     *telemetry
+	// telemetryMu guards warnedGauges, the gauge definitions already warned about
+	telemetryMu  sync.Mutex
+	warnedGauges map[string]bool
 }
 
-// CreateAgents creates an Agent structure for every Agent member of the LAN cluster
+// CreateAgents creates an Agent structure for every alive Agent member of the LAN cluster
+// that matches the fan out filters. Members in any other serf status can't be queried
+// so they only get an unreachable marker.
 func CreateAgents(client *api.Client, i *integration.Integration, args *args.ArgumentList) (agents []*Agent, leader *Agent, err error) {
 	members, err := client.Agent().Members(false)
 	if err != nil {
@@ -42,17 +57,43 @@ func CreateAgents(client *api.Client, i *integration.Integration, args *args.Arg
 		return
 	}
 
+	filter, err := newMemberFilter(client, args)
+	if err != nil {
+		log.Error("Error creating member filter: %s", err.Error())
+		return
+	}
+
+	nodeIDs := &nodeIDLookup{client: client}
+
 	agents = make([]*Agent, 0, len(members))
 	for _, member := range members {
+		// the leader is always collected since it's needed for the Datacenter
+		if !sameHost(member.Addr, leaderAddr) && !filter.matches(member) {
+			log.Debug("Skipping Agent '%s' excluded by the fan out filters", member.Name)
+			continue
+		}
+
+		var nodeID string
+		if args.NodeIDEntityKey {
+			nodeID = nodeIDs.get(member)
+		}
 
-		memberNameIDAttr := integration.NewIDAttribute("co-agent", member.Name)
-		entity, err := i.Entity(fmt.Sprintf("%s:%d", member.Addr, member.Port), "co-agent", memberNameIDAttr)
+		port := strconv.Itoa(int(member.Port))
+		entity, err := NewEntity(i, args, member.Name, member.Addr, port, nodeID)
 		if err != nil {
 			log.Error("Error creating entity for Agent '%s': %s", member.Name, err.Error())
 			continue
 		}
 
-		apiConfig, err := args.CreateAPIConfig(member.Addr)
+		if status := MemberStatus(member); status != MemberStatusAlive {
+			log.Debug("Skipping Agent '%s' with serf status '%s'", member.Name, status)
+			if args.HasMetrics() {
+				NewAgent(nil, entity, member.Name, member.Addr, port, member.Tags["dc"], args.ClusterName).setUnreachableMetrics(status)
+			}
+			continue
+		}
+
+		apiConfig, err := args.CreateMemberAPIConfig(member)
 		if err != nil {
 			log.Error("Error creating httpClient for Agent '%s': %s", member.Name, err.Error())
 			continue
@@ -64,11 +105,11 @@ func CreateAgents(client *api.Client, i *integration.Integration, args *args.Arg
 			continue
 		}
 
-		agent := NewAgent(client, entity, member.Name, member.Addr, member.Tags["dc"])
+		agent := NewAgent(client, entity, member.Name, member.Addr, port, member.Tags["dc"], args.ClusterName)
 		agents = append(agents, agent)
 
 		// we need to identify the leader to collect catalog
-		if member.Addr == leaderAddr {
+		if sameHost(member.Addr, leaderAddr) {
 			leader = agent
 		}
 	}
@@ -79,14 +120,61 @@ func CreateAgents(client *api.Client, i *integration.Integration, args *args.Arg
 }
 
 // NewAgent creates a new agent from the given client and Entity
-func NewAgent(client *api.Client, entity *integration.Entity, name, ipAddr, datacenter string) *Agent {
+func NewAgent(client *api.Client, entity *integration.Entity, name, ipAddr, port, datacenter, clusterName string) *Agent {
 	return &Agent{
-		Client:     client,
-		entity:     entity,
-		ipAddr:     ipAddr,
-		datacenter: datacenter,
-		name:       name,
+		Client:      client,
+		entity:      entity,
+		ipAddr:      ipAddr,
+		port:        port,
+		datacenter:  datacenter,
+		clusterName: clusterName,
+		name:        name,
+	}
+}
+
+// NewEntity creates the entity of the agent member. It's keyed by the member address and port
+// unless node_id_entity_key is set, then it's keyed by the node ID so it survives address changes.
+// An unknown node ID is an error rather than a fallback to the address, which would create a second entity.
+func NewEntity(i *integration.Integration, al *args.ArgumentList, name, ipAddr, port, nodeID string) (*integration.Entity, error) {
+	key := net.JoinHostPort(ipAddr, port)
+	if al.NodeIDEntityKey {
+		if nodeID == "" {
+			return nil, fmt.Errorf("node ID of Agent '%s' is unknown", name)
+		}
+		key = nodeID
+	}
+
+	memberNameIDAttr := integration.NewIDAttribute("co-agent", name)
+	idAttrs := append([]integration.IDAttribute{memberNameIDAttr}, args.ClusterIDAttributes(al.ClusterName)...)
+	return i.Entity(key, "co-agent", idAttrs...)
+}
+
+// nodeIDLookup finds the node ID of members. Members carry it in the id tag, the catalog is only
+// queried once for members lacking it.
+type nodeIDLookup struct {
+	client  *api.Client
+	catalog map[string]string
+}
+
+func (n *nodeIDLookup) get(member *api.AgentMember) string {
+	if id := member.Tags["id"]; id != "" {
+		return id
 	}
+
+	if n.catalog == nil {
+		n.catalog = make(map[string]string)
+
+		nodes, _, err := n.client.Catalog().Nodes(nil)
+		if err != nil {
+			log.Error("Error getting catalog nodes: %s", err.Error())
+		}
+
+		for _, node := range nodes {
+			n.catalog[node.Node] = node.ID
+		}
+	}
+
+	return n.catalog[member.Name]
 }
 
 func (a *Agent) processConfig(config map[string]interface{}, configPrefix string) {
@@ -134,13 +222,14 @@ func (a *Agent) collectPeerCount(metricSet *metric.Set) error {
 	return nil
 }
 
-func (a *Agent) collectLatencyMetrics(metricSet *metric.Set) error {
+func (a *Agent) collectLatencyMetrics(metricSet *metric.Set, perms *permissions.Set) error {
 	log.Debug("Starting latency metric collection for Agent %s", a.entity.Metadata.Name)
 
-	nodes, _, err := a.Client.Coordinate().Nodes(nil)
+	nodes, meta, err := a.Client.Coordinate().Nodes(nil)
 	if err != nil {
 		return err
 	}
+	perms.Filtered(permissions.AgentLatency, meta)
 
 	if len(nodes) == 1 {
 		return errors.New("could not collect latency metrics because the cluster only contains 1 node")
@@ -160,112 +249,206 @@ func (a *Agent) collectLatencyMetrics(metricSet *metric.Set) error {
 
 // HostPort returns the host:port of the agent
 func (a *Agent) HostPort() string {
-	return a.entity.Metadata.Name
+	return net.JoinHostPort(a.ipAddr, a.port)
+}
+
+// Permissions returns the perms probing the agent collectors against this agent, since agent:read
+// rules are granted per node
+func (a *Agent) Permissions(perms *permissions.Set) *permissions.Set {
+	return perms.Member(a.name, a.Client)
+}
+
+// metricsInfo is the /v1/agent/metrics response of an agent
+type metricsInfo struct {
+	Gauges   []api.GaugeValue
+	Counters []sampledValue
+	Samples  []sampledValue
 }
 
-// CollectCoreMetrics collects metrics for an Agent
-func (a *Agent) CollectCoreMetrics(metricSet *metric.Set, gaugeDefs, counterDefs []*metrics.MetricDefinition, timerDefs []*metrics.TimerDefinition) error {
+// sampledValue is an api.SampledValue along with the Rate Consul reports, the Sum per second
+// of the telemetry interval, which api.SampledValue leaves out
+type sampledValue struct {
+	api.SampledValue
+	Rate float64
+}
+
+// getMetrics retrieves the telemetry of the agent. It's queried raw to decode the sample rates,
+// the response has no query meta but the query doesn't fail without it.
+func (a *Agent) getMetrics() (*metricsInfo, error) {
+	var out *metricsInfo
+	if _, err := a.Client.Raw().Query("/v1/agent/metrics", &out, nil); err != nil {
+		return nil, err
+	}
+
+	if out == nil {
+		return nil, errors.New("empty response to /v1/agent/metrics")
+	}
+
+	return out, nil
+}
+
+// CollectCoreMetrics collects metrics for an Agent. Labeled series of definitions with Labels
+// go to metric sets created with newMetricSet, the rest to metricSet.
+func (a *Agent) CollectCoreMetrics(metricSet *metric.Set, newMetricSet metrics.MetricSetFactory, gaugeDefs, counterDefs []*metrics.MetricDefinition, timerDefs []*metrics.TimerDefinition) error {
 	log.Debug("Starting core metric collection for Agent %s", a.entity.Metadata.Name)
-	metricInfo, err := a.Client.Agent().Metrics()
+	metricInfo, err := a.getMetrics()
 	if err != nil {
 		return err
 	}
 
-	// collect gauges
+	// match the series whatever the metrics prefix and hostname of the agent
+	a.getTelemetry().normalizeMetrics(metricInfo, definitionKeys(gaugeDefs, counterDefs, timerDefs))
+
+	sets := newLabeledSets(metricSet, newMetricSet)
+
+	// collect gauges, they're reported every interval so a missing one means its name didn't match
 	if gaugeDefs != nil {
-		collectGaugeMetrics(metricSet, metricInfo.Gauges, gaugeDefs)
+		if missing := collectGaugeMetrics(sets, metricInfo.Gauges, gaugeDefs); len(missing) > 0 {
+			a.warnMissingGauges(missing)
+		}
 	}
 
 	// collect counters
 	if counterDefs != nil {
-		collectCounterMetrics(metricSet, metricInfo.Counters, counterDefs)
+		collectCounterMetrics(sets, metricInfo.Counters, counterDefs)
 	}
 
 	// collect timers
 	if timerDefs != nil {
-		collectTimerMetrics(metricSet, metricInfo.Samples, timerDefs)
+		collectTimerMetrics(sets, metricInfo.Samples, timerDefs, telemetryInterval(metricInfo.Counters, metricInfo.Samples))
 	}
 
 	log.Debug("Finished core metric collection for Agent %s", a.entity.Metadata.Name)
 	return nil
 }
 
-func collectGaugeMetrics(metricSet *metric.Set, gauges []api.GaugeValue, defs []*metrics.MetricDefinition) {
+// collectGaugeMetrics returns the API keys of the definitions no gauge matched.
+// Gauges are point in time values that can't be summed, so each label group reports its first series.
+func collectGaugeMetrics(sets *labeledSets, gauges []api.GaugeValue, defs []*metrics.MetricDefinition) []string {
+	var missing []string
 	for _, def := range defs {
-		found := false
-
-		// Look through all gauges for metric
+		// Look through all gauges for the series of the metric
+		var matches []api.GaugeValue
+		var matchLabels []map[string]string
 		for _, gauge := range gauges {
-			// If found, record and break
 			if def.APIKey == gauge.Name {
-				value := gauge.Value
-
-				// special case where we need to convert nanoseconds to milliseconds
-				if def.APIKey == "consul.runtime.total_gc_pause_ns" {
-					value /= 1000000
-				}
-
-				found = true
-				metrics.SetMetric(metricSet, def.MetricName, value, def.SourceType)
-				break
+				matches = append(matches, gauge)
+				matchLabels = append(matchLabels, gauge.Labels)
 			}
 		}
 
-		if !found {
+		if len(matches) == 0 {
 			log.Debug("Did not find metric '%s' matching API key '%s'", def.MetricName, def.APIKey)
+			missing = append(missing, def.APIKey)
+			continue
+		}
+
+		for _, group := range groupByLabels(matchLabels, def.Labels) {
+			value := matches[group.indexes[0]].Value
+
+			// special case where we need to convert nanoseconds to milliseconds
+			if def.APIKey == "consul.runtime.total_gc_pause_ns" {
+				value /= 1000000
+			}
+
+			metrics.SetMetric(sets.get(group.labels), def.MetricName, value, def.SourceType)
 		}
 	}
+
+	return missing
 }
 
-func collectCounterMetrics(metricSet *metric.Set, counters []api.SampledValue, defs []*metrics.MetricDefinition) {
+func collectCounterMetrics(sets *labeledSets, counters []sampledValue, defs []*metrics.MetricDefinition) {
 	for _, def := range defs {
-		found := false
-
-		// Look through all counters for metric
-		for _, counter := range counters {
-			// If found, record and break
-			if def.APIKey == counter.Name {
-				found = true
-				metrics.SetMetric(metricSet, def.MetricName, counter.Count, def.SourceType)
-				break
-			}
+		groups, samples := groupSamples(counters, def.APIKey, def.Labels)
+		if len(groups) == 0 {
+			log.Debug("Did not find metric '%s' matching API key '%s'", def.MetricName, def.APIKey)
+			continue
 		}
 
-		if !found {
-			log.Debug("Did not find metric '%s' matching API key '%s'", def.MetricName, def.APIKey)
+		for idx, group := range groups {
+			metrics.SetMetric(sets.get(group.labels), def.MetricName, samples[idx].Rate, def.SourceType)
 		}
 	}
 }
 
-func collectTimerMetrics(metricSet *metric.Set, timers []api.SampledValue, defs []*metrics.TimerDefinition) {
-	lookup := make(map[string]*api.SampledValue)
+// collectTimerMetrics reports a statistic of the timer samples of each definition. Sample counts per
+// second need the length of the interval, in seconds, definitions reporting them are skipped if it's unknown.
+func collectTimerMetrics(sets *labeledSets, timers []sampledValue, defs []*metrics.TimerDefinition, interval float64) {
+	type grouped struct {
+		groups  []*labelGroup
+		samples []sampledValue
+	}
+	lookup := make(map[string]*grouped)
 
 	for _, def := range defs {
 
 		// Check if the timer is cached, if not search for it.
-		sample, ok := lookup[def.APIKey]
+		key := def.APIKey + "|" + strings.Join(def.Labels, ",")
+		timer, ok := lookup[key]
 		if !ok {
-			for _, timer := range timers {
-				if def.APIKey == timer.Name {
-					sample = &timer
-					lookup[def.APIKey] = sample
-					break
-				}
-			}
+			timer = &grouped{}
+			timer.groups, timer.samples = groupSamples(timers, def.APIKey, def.Labels)
+			lookup[key] = timer
 		}
 
-		if sample == nil {
+		if len(timer.groups) == 0 {
 			log.Debug("Did not find metric '%s' matching API key '%s'", def.MetricName, def.APIKey)
 			continue
 		}
 
+		if def.Operation == metrics.CountPerSecond && interval == 0 {
+			log.Debug("Did not collect metric '%s', no sample tells the telemetry interval", def.MetricName)
+			continue
+		}
+
 		// Calculate/collect statistical sample
-		value := calculateStatValue(def.Operation, sample)
-		metrics.SetMetric(metricSet, def.MetricName, value, def.SourceType)
+		for idx, group := range timer.groups {
+			value := calculateStatValue(def.Operation, &timer.samples[idx], interval)
+			metrics.SetMetric(sets.get(group.labels), def.MetricName, value, def.SourceType)
+		}
+	}
+}
+
+// groupSamples groups the series of a metric by the definition labels, returning the merged sample of each group
+func groupSamples(samples []sampledValue, name string, labelNames []string) ([]*labelGroup, []sampledValue) {
+	var matches []sampledValue
+	var matchLabels []map[string]string
+	for _, sample := range samples {
+		if sample.Name == name {
+			matches = append(matches, sample)
+			matchLabels = append(matchLabels, sample.Labels)
+		}
+	}
+
+	groups := groupByLabels(matchLabels, labelNames)
+	merged := make([]sampledValue, 0, len(groups))
+	for _, group := range groups {
+		groupSamples := make([]sampledValue, 0, len(group.indexes))
+		for _, idx := range group.indexes {
+			groupSamples = append(groupSamples, matches[idx])
+		}
+		merged = append(merged, mergeSamples(groupSamples))
 	}
+
+	return groups, merged
 }
 
-func calculateStatValue(operation metrics.StatOperation, sample *api.SampledValue) float64 {
+// telemetryInterval returns the length in seconds of the interval Consul aggregated the samples over,
+// from the Sum and Rate of the first sample with both, or 0 if none has. /v1/agent/metrics doesn't report it.
+func telemetryInterval(samples ...[]sampledValue) float64 {
+	for _, series := range samples {
+		for _, sample := range series {
+			if sample.Sum != 0 && sample.Rate != 0 {
+				return sample.Sum / sample.Rate
+			}
+		}
+	}
+
+	return 0
+}
+
+func calculateStatValue(operation metrics.StatOperation, sample *sampledValue, interval float64) float64 {
 	var value float64
 	switch operation {
 	case metrics.Average:
@@ -274,6 +457,16 @@ func calculateStatValue(operation metrics.StatOperation, sample *api.SampledValu
 		value = sample.Max
 	case metrics.Count:
 		value = float64(sample.Count)
+	case metrics.Min:
+		value = sample.Min
+	case metrics.Stddev:
+		value = sample.Stddev
+	case metrics.Sum:
+		value = sample.Sum
+	case metrics.Rate:
+		value = sample.Rate
+	case metrics.CountPerSecond:
+		value = float64(sample.Count) / interval
 	}
 
 	return value
@@ -303,6 +496,27 @@ func getLeaderAddr(client *api.Client) (string, error) {
 		return "", err
 	}
 
-	// Addr comes in the form IP:Port splitting and only returning IP
-	return strings.Split(leaderAddr, ":")[0], nil
+	// there's no leader during an election, the agents are still collected
+	if leaderAddr == "" {
+		return "", nil
+	}
+
+	// Addr comes in the form IP:Port, or [IP]:Port for IPv6, only returning IP
+	host, _, err := net.SplitHostPort(leaderAddr)
+	if err != nil {
+		return "", err
+	}
+
+	return host, nil
+}
+
+// sameHost compares two member addresses, IPs are compared by value
+// so different notations of the same IPv6 address are equal
+func sameHost(a, b string) bool {
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+	if ipA != nil && ipB != nil {
+		return ipA.Equal(ipB)
+	}
+
+	return a == b
 }
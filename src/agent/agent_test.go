@@ -3,14 +3,114 @@ package agent
 import (
 	"fmt"
 	"net/http"
+	"reflect"
 	"testing"
 
 	"github.com/hashicorp/consul/api"
 	"github.com/newrelic/infra-integrations-sdk/v3/integration"
 	"github.com/newrelic/nri-consul/src/args"
+	"github.com/newrelic/nri-consul/src/metrics"
 	"github.com/newrelic/nri-consul/src/testutils"
 )
 
+// membersFixture is a LAN pool of two servers and two clients, consul-0 being the leader
+const membersFixture = `[
+	{
+		"Name": "consul-0",
+		"Addr": "10.0.0.1",
+		"Port": 8301,
+		"Tags": {
+			"build": "1.2.1:39f93f01",
+			"dc": "dev",
+			"id": "c7f88fba-f8d9-94a9-3627-523398acf7db",
+			"port": "8300",
+			"raft_vsn": "3",
+			"role": "consul",
+			"segment": "",
+			"vsn": "2",
+			"vsn_max": "3",
+			"vsn_min": "2",
+			"wan_join_port": "8302"
+		},
+		"Status": 1,
+		"ProtocolMin": 1,
+		"ProtocolMax": 5,
+		"ProtocolCur": 2,
+		"DelegateMin": 2,
+		"DelegateMax": 5,
+		"DelegateCur": 4
+	},
+	{
+		"Name": "consul-1",
+		"Addr": "10.0.0.2",
+		"Port": 8301,
+		"Tags": {
+			"build": "1.2.1:39f93f01",
+			"dc": "dev",
+			"id": "fbfe7e9b-5d30-284b-cc05-d2d5cc43688d",
+			"port": "8300",
+			"raft_vsn": "3",
+			"role": "consul",
+			"segment": "",
+			"vsn": "2",
+			"vsn_max": "3",
+			"vsn_min": "2",
+			"wan_join_port": "8302"
+		},
+		"Status": 1,
+		"ProtocolMin": 1,
+		"ProtocolMax": 5,
+		"ProtocolCur": 2,
+		"DelegateMin": 2,
+		"DelegateMax": 5,
+		"DelegateCur": 4
+	},
+	{
+		"Name": "web-0",
+		"Addr": "10.0.0.3",
+		"Port": 8301,
+		"Tags": {
+			"build": "1.2.1:39f93f01",
+			"dc": "dev",
+			"id": "8b4b2a1c-0c65-4a4f-a4a4-2a0e0c2c8b51",
+			"role": "node",
+			"segment": "alpha",
+			"vsn": "2",
+			"vsn_max": "3",
+			"vsn_min": "2"
+		},
+		"Status": 1,
+		"ProtocolMin": 1,
+		"ProtocolMax": 5,
+		"ProtocolCur": 2,
+		"DelegateMin": 2,
+		"DelegateMax": 5,
+		"DelegateCur": 4
+	},
+	{
+		"Name": "gateway-0",
+		"Addr": "10.0.0.4",
+		"Port": 8301,
+		"Tags": {
+			"build": "1.2.1:39f93f01",
+			"dc": "dev",
+			"id": "3f2b1c4d-7e8a-4b9c-8d1e-2f3a4b5c6d7e",
+			"role": "node",
+			"segment": "",
+			"vsn": "2",
+			"vsn_max": "3",
+			"vsn_min": "2"
+		},
+		"Status": 1,
+		"ProtocolMin": 1,
+		"ProtocolMax": 5,
+		"ProtocolCur": 2,
+		"DelegateMin": 2,
+		"DelegateMax": 5,
+		"DelegateCur": 4
+	}
+]`
+
 func TestCreateAgents(t *testing.T) {
 	mux, hostname, port, serverClose := testutils.SetupServer()
 	defer serverClose()
@@ -37,6 +137,58 @@ func TestCreateAgents(t *testing.T) {
 		t.Fatalf("Unexpected error %s", err.Error())
 	}
 
+	mux.HandleFunc("/v1/agent/members", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, membersFixture)
+	})
+
+	mux.HandleFunc("/v1/status/leader", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `"10.0.0.1:8300"`)
+	})
+
+	agents, leader, err := CreateAgents(client, i, &arg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if len(agents) != 4 {
+		t.Fatalf("Expected 4 agents got %d", len(agents))
+	}
+
+	agent := agents[0]
+	if agent.entity.Metadata.Name != "10.0.0.1:8301" {
+		t.Errorf("Expected Entity name 'consul-0' got %s", agent.entity.Metadata.Name)
+	} else if agent != leader {
+		t.Error("Leader was no correclty set")
+	}
+}
+
+func TestCreateAgents_NodeIDEntityKey(t *testing.T) {
+	mux, hostname, port, serverClose := testutils.SetupServer()
+	defer serverClose()
+
+	arg := args.ArgumentList{
+		Hostname:        hostname,
+		Port:            port,
+		EnableSSL:       false,
+		Timeout:         "0s",
+		NodeIDEntityKey: true,
+	}
+
+	apiConfig, err := arg.CreateAPIConfig(arg.Hostname)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	client, err := api.NewClient(apiConfig)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	i, err := integration.New("test", "1.0.0")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
 	mux.HandleFunc("/v1/agent/members", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprint(w, `[
 			{
@@ -44,25 +196,145 @@ func TestCreateAgents(t *testing.T) {
 				"Addr": "10.0.0.1",
 				"Port": 8301,
 				"Tags": {
-					"build": "1.2.1:39f93f01",
 					"dc": "dev",
 					"id": "c7f88fba-f8d9-94a9-3627-523398acf7db",
-					"port": "8300",
-					"raft_vsn": "3",
-					"role": "consul",
-					"segment": "",
-					"vsn": "2",
-					"vsn_max": "3",
-					"vsn_min": "2",
-					"wan_join_port": "8302"
+					"role": "consul"
 				},
-				"Status": 1,
-				"ProtocolMin": 1,
-				"ProtocolMax": 5,
-				"ProtocolCur": 2,
-				"DelegateMin": 2,
-				"DelegateMax": 5,
-				"DelegateCur": 4
+				"Status": 1
+			},
+			{
+				"Name": "client-0",
+				"Addr": "10.0.0.2",
+				"Port": 8301,
+				"Tags": {
+					"dc": "dev",
+					"role": "node"
+				},
+				"Status": 1
+			},
+			{
+				"Name": "client-1",
+				"Addr": "10.0.0.3",
+				"Port": 8301,
+				"Tags": {
+					"dc": "dev",
+					"role": "node"
+				},
+				"Status": 1
+			}
+		]`)
+	})
+
+	mux.HandleFunc("/v1/status/leader", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `"10.0.0.1:8300"`)
+	})
+
+	// members without the id tag are looked up in the catalog, only once
+	catalogRequests := 0
+	mux.HandleFunc("/v1/catalog/nodes", func(w http.ResponseWriter, r *http.Request) {
+		catalogRequests++
+		w.Header().Set("X-Consul-LastContact", "0")
+		w.Header().Set("X-Consul-KnownLeader", "true")
+		fmt.Fprint(w, `[
+			{
+				"ID": "0a9c8a5e-8d2c-4b3a-9f3e-2f6c1d7b8e90",
+				"Node": "client-0",
+				"Address": "10.0.0.2"
+			}
+		]`)
+	})
+
+	agents, _, err := CreateAgents(client, i, &arg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if catalogRequests != 1 {
+		t.Errorf("Expected 1 catalog request got %d", catalogRequests)
+	}
+
+	expected := []struct {
+		name     string
+		hostPort string
+	}{
+		{"c7f88fba-f8d9-94a9-3627-523398acf7db", "10.0.0.1:8301"},
+		{"0a9c8a5e-8d2c-4b3a-9f3e-2f6c1d7b8e90", "10.0.0.2:8301"},
+		// client-1 is skipped since its node ID is unknown
+	}
+
+	if len(agents) != len(expected) {
+		t.Fatalf("Expected %d agents got %d", len(expected), len(agents))
+	}
+
+	for idx, want := range expected {
+		if out := agents[idx].entity.Metadata.Name; out != want.name {
+			t.Errorf("Expected Entity name %s got %s", want.name, out)
+		}
+		if out := agents[idx].HostPort(); out != want.hostPort {
+			t.Errorf("Expected address %s got %s", want.hostPort, out)
+		}
+	}
+}
+
+func TestCreateAgents_SkipsUnreachable(t *testing.T) {
+	mux, hostname, port, serverClose := testutils.SetupServer()
+	defer serverClose()
+
+	arg := args.ArgumentList{
+		Hostname:  hostname,
+		Port:      port,
+		EnableSSL: false,
+		Timeout:   "0s",
+		// unreachable agents are still namespaced by cluster
+		ClusterName: "east",
+	}
+
+	apiConfig, err := arg.CreateAPIConfig(arg.Hostname)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	client, err := api.NewClient(apiConfig)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	i, err := integration.New("test", "1.0.0")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	mux.HandleFunc("/v1/agent/members", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[
+			{
+				"Name": "consul-0",
+				"Addr": "10.0.0.1",
+				"Port": 8301,
+				"Tags": {
+					"dc": "dev",
+					"role": "consul"
+				},
+				"Status": 1
+			},
+			{
+				"Name": "consul-1",
+				"Addr": "10.0.0.2",
+				"Port": 8301,
+				"Tags": {
+					"dc": "dev",
+					"role": "consul"
+				},
+				"Status": 4
+			},
+			{
+				"Name": "client-0",
+				"Addr": "10.0.0.3",
+				"Port": 8301,
+				"Tags": {
+					"dc": "dev",
+					"role": "node"
+				},
+				"Status": 3
 			}
 		]`)
 	})
@@ -78,13 +350,229 @@ func TestCreateAgents(t *testing.T) {
 
 	if len(agents) != 1 {
 		t.Fatalf("Expected 1 agent got %d", len(agents))
+	} else if agents[0] != leader {
+		t.Error("Leader was no correclty set")
 	}
 
-	agent := agents[0]
-	if agent.entity.Metadata.Name != "10.0.0.1:8301" {
-		t.Errorf("Expected Entity name 'consul-0' got %s", agent.entity.Metadata.Name)
-	} else if agent != leader {
-		t.Error("Leader was no correclty set")
+	expected := map[string]map[string]interface{}{
+		"10.0.0.2:8301": {
+			"event_type":        "ConsulAgentSample",
+			"displayName":       "10.0.0.2:8301",
+			"entityName":        "co-agent:10.0.0.2:8301",
+			"ip":                "10.0.0.2",
+			"port":              "8301",
+			"datacenter":        "dev",
+			"clusterName":       "east",
+			"serfStatus":        "failed",
+			"agent.unreachable": float64(1),
+		},
+		"10.0.0.3:8301": {
+			"event_type":        "ConsulAgentSample",
+			"displayName":       "10.0.0.3:8301",
+			"entityName":        "co-agent:10.0.0.3:8301",
+			"ip":                "10.0.0.3",
+			"port":              "8301",
+			"datacenter":        "dev",
+			"clusterName":       "east",
+			"serfStatus":        "left",
+			"agent.unreachable": float64(1),
+		},
+	}
+
+	for _, entity := range i.Entities {
+		want, ok := expected[entity.Metadata.Name]
+		if !ok {
+			if len(entity.Metrics) != 0 {
+				t.Errorf("Unexpected metrics for entity %s", entity.Metadata.Name)
+			}
+			continue
+		}
+
+		if len(entity.Metrics) != 1 {
+			t.Errorf("Expected 1 metric set for entity %s got %d", entity.Metadata.Name, len(entity.Metrics))
+		} else if !reflect.DeepEqual(entity.Metrics[0].Metrics, want) {
+			t.Errorf("Expected %+v got %+v", want, entity.Metrics[0].Metrics)
+		}
+	}
+}
+
+func TestCreateAgents_IPv6(t *testing.T) {
+	mux, hostname, port, serverClose := testutils.SetupServer()
+	defer serverClose()
+
+	arg := args.ArgumentList{
+		Hostname:  hostname,
+		Port:      port,
+		EnableSSL: false,
+		Timeout:   "0s",
+	}
+
+	apiConfig, err := arg.CreateAPIConfig(arg.Hostname)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	client, err := api.NewClient(apiConfig)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	i, err := integration.New("test", "1.0.0")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	mux.HandleFunc("/v1/agent/members", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[
+			{
+				"Name": "consul-0",
+				"Addr": "fd00::1",
+				"Port": 8301,
+				"Tags": {
+					"dc": "dev",
+					"role": "consul"
+				},
+				"Status": 1
+			},
+			{
+				"Name": "consul-1",
+				"Addr": "fd00::2",
+				"Port": 8301,
+				"Tags": {
+					"dc": "dev",
+					"role": "consul"
+				},
+				"Status": 1
+			}
+		]`)
+	})
+
+	// the leader address uses a different notation of the same IPv6 address
+	mux.HandleFunc("/v1/status/leader", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `"[fd00:0:0::1]:8300"`)
+	})
+
+	agents, leader, err := CreateAgents(client, i, &arg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if len(agents) != 2 {
+		t.Fatalf("Expected 2 agents got %d", len(agents))
+	}
+
+	if leader == nil {
+		t.Fatal("Leader was not set")
+	} else if leader.HostPort() != "[fd00::1]:8301" {
+		t.Errorf("Expected leader '[fd00::1]:8301' got %s", leader.HostPort())
+	}
+
+	if agents[1].HostPort() != "[fd00::2]:8301" {
+		t.Errorf("Expected Entity name '[fd00::2]:8301' got %s", agents[1].HostPort())
+	}
+
+	if agents[1].Client == nil {
+		t.Error("Expected a client for the IPv6 agent")
+	}
+}
+
+func Test_getLeaderAddr_Invalid(t *testing.T) {
+	mux, hostname, port, serverClose := testutils.SetupServer()
+	defer serverClose()
+
+	arg := args.ArgumentList{
+		Hostname:  hostname,
+		Port:      port,
+		EnableSSL: false,
+		Timeout:   "0s",
+	}
+
+	apiConfig, err := arg.CreateAPIConfig(arg.Hostname)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	client, err := api.NewClient(apiConfig)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	// an IPv6 leader address without brackets is ambiguous
+	mux.HandleFunc("/v1/status/leader", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `"fd00::1:8300"`)
+	})
+
+	if _, err := getLeaderAddr(client); err == nil {
+		t.Error("Expected error")
+	}
+}
+
+func TestCreateAgents_NoLeader(t *testing.T) {
+	mux, hostname, port, serverClose := testutils.SetupServer()
+	defer serverClose()
+
+	arg := args.ArgumentList{
+		Hostname:  hostname,
+		Port:      port,
+		EnableSSL: false,
+		Timeout:   "0s",
+	}
+
+	apiConfig, err := arg.CreateAPIConfig(arg.Hostname)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	client, err := api.NewClient(apiConfig)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	i, err := integration.New("test", "1.0.0")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	mux.HandleFunc("/v1/agent/members", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, membersFixture)
+	})
+
+	// an election is in progress
+	mux.HandleFunc("/v1/status/leader", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `""`)
+	})
+
+	agents, leader, err := CreateAgents(client, i, &arg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if len(agents) != 4 {
+		t.Errorf("Expected 4 agents got %d", len(agents))
+	}
+
+	if leader != nil {
+		t.Errorf("Expected no leader got %+v", leader)
+	}
+}
+
+func Test_sameHost(t *testing.T) {
+	testCases := []struct {
+		a, b string
+		want bool
+	}{
+		{"10.0.0.1", "10.0.0.1", true},
+		{"10.0.0.1", "10.0.0.2", false},
+		{"fd00::1", "fd00:0:0::1", true},
+		{"fd00::1", "fd00::2", false},
+		{"consul-0", "consul-0", true},
+		{"consul-0", "10.0.0.1", false},
+	}
+
+	for _, tc := range testCases {
+		if out := sameHost(tc.a, tc.b); out != tc.want {
+			t.Errorf("sameHost(%s, %s): expected %t got %t", tc.a, tc.b, tc.want, out)
+		}
 	}
 }
 
@@ -206,16 +694,69 @@ func Test_Agent_Name(t *testing.T) {
 		t.Fatalf("Unexpected error %s", err.Error())
 	}
 
-	entity, err := i.Entity("test", "agent")
+	// the entity may be keyed by node ID so the address doesn't come from its name
+	entity, err := i.Entity("70ccd111-96de-a058-7be7-81a00fa7ca17", "agent")
 	if err != nil {
 		t.Fatalf("Unexpected error %s", err.Error())
 	}
 
-	agent := &Agent{
-		entity: entity,
+	testCases := []struct {
+		ipAddr string
+		want   string
+	}{
+		{"10.0.0.1", "10.0.0.1:8301"},
+		{"fd00::1", "[fd00::1]:8301"},
+	}
+
+	for _, tc := range testCases {
+		agent := NewAgent(nil, entity, "consul-0", tc.ipAddr, "8301", "dc1", "")
+		if out := agent.HostPort(); out != tc.want {
+			t.Errorf("Expected %s got %s", tc.want, out)
+		}
+	}
+}
+
+func Test_MemberStatus(t *testing.T) {
+	testCases := []struct {
+		status int
+		want   string
+	}{
+		{0, MemberStatusNone},
+		{1, MemberStatusAlive},
+		{2, MemberStatusLeaving},
+		{3, MemberStatusLeft},
+		{4, MemberStatusFailed},
+		{42, MemberStatusNone},
+	}
+
+	for _, tc := range testCases {
+		if got := MemberStatus(&api.AgentMember{Status: tc.status}); got != tc.want {
+			t.Errorf("Expected status %d to be %s got %s", tc.status, tc.want, got)
+		}
+	}
+}
+
+func Test_telemetryInterval(t *testing.T) {
+	sample := func(count int, sum, rate float64) sampledValue {
+		return sampledValue{SampledValue: api.SampledValue{Count: count, Sum: sum}, Rate: rate}
+	}
+
+	// the first sample with both a Sum and a Rate tells the interval, here 60s
+	counters := []sampledValue{sample(0, 0, 0)}
+	timers := []sampledValue{sample(3, 30, 0.5), sample(1, 5, 0.5)}
+	if out := telemetryInterval(counters, timers); out != 60 {
+		t.Errorf("Expected 60 got %v", out)
+	}
+
+	if out := telemetryInterval(counters); out != 0 {
+		t.Errorf("Expected 0 got %v", out)
+	}
+
+	if out := calculateStatValue(metrics.CountPerSecond, &timers[0], 60); out != 0.05 {
+		t.Errorf("Expected 0.05 got %v", out)
 	}
 
-	if agent.HostPort() != entity.Metadata.Name {
-		t.Errorf("Expected %s got %s", entity.Metadata.Name, agent.HostPort())
+	if out := calculateStatValue(metrics.Rate, &timers[0], 60); out != 0.5 {
+		t.Errorf("Expected 0.5 got %v", out)
 	}
 }
@@ -0,0 +1,137 @@
+package agent
+
+import (
+	"regexp"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/newrelic/infra-integrations-sdk/v3/log"
+	"github.com/newrelic/nri-consul/src/args"
+)
+
+// memberFilter decides which LAN members are collected during fan out
+type memberFilter struct {
+	serversOnly bool
+	includeName *regexp.Regexp
+	excludeName *regexp.Regexp
+	includeTags map[string]string
+	excludeTags map[string]string
+	// nodeMeta is the node metadata filter, checked against the node of each member
+	nodeMeta map[string]string
+	// metaNodes are the names of the nodes matching nodeMeta, nil if they couldn't be listed at once
+	metaNodes map[string]struct{}
+	client    *api.Client
+}
+
+// newMemberFilter creates a memberFilter from the fan out arguments.
+// Node metadata is not part of the member data so the matching nodes are retrieved from the catalog,
+// falling back to looking up the node of each member when they can't be listed at once.
+func newMemberFilter(client *api.Client, al *args.ArgumentList) (*memberFilter, error) {
+	filter := &memberFilter{
+		serversOnly: al.FanOutServersOnly,
+	}
+
+	var err error
+	if filter.includeName, err = compileOptional(al.FanOutIncludeName); err != nil {
+		return nil, err
+	}
+
+	if filter.excludeName, err = compileOptional(al.FanOutExcludeName); err != nil {
+		return nil, err
+	}
+
+	if filter.includeTags, err = args.ParseKeyValues(al.FanOutIncludeTags); err != nil {
+		return nil, err
+	}
+
+	if filter.excludeTags, err = args.ParseKeyValues(al.FanOutExcludeTags); err != nil {
+		return nil, err
+	}
+
+	if filter.nodeMeta, err = args.ParseKeyValues(al.FanOutIncludeNodeMeta); err != nil {
+		return nil, err
+	}
+
+	if len(filter.nodeMeta) > 0 {
+		filter.client = client
+		nodes, _, err := client.Catalog().Nodes(&api.QueryOptions{NodeMeta: filter.nodeMeta})
+		if err != nil {
+			log.Warn("Error listing nodes matching the node metadata filter, looking up each member node: %s", err.Error())
+		} else {
+			filter.metaNodes = make(map[string]struct{}, len(nodes))
+			for _, node := range nodes {
+				filter.metaNodes[node.Node] = struct{}{}
+			}
+		}
+	}
+
+	return filter, nil
+}
+
+// compileOptional compiles the expression, an empty expression results in a nil Regexp
+func compileOptional(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+
+	return regexp.Compile(expr)
+}
+
+// matches returns true if the member must be collected
+func (f *memberFilter) matches(member *api.AgentMember) bool {
+	if f.serversOnly && member.Tags[api.MemberTagKeyRole] != api.MemberTagValueRoleServer {
+		return false
+	}
+
+	if f.includeName != nil && !f.includeName.MatchString(member.Name) {
+		return false
+	}
+
+	if f.excludeName != nil && f.excludeName.MatchString(member.Name) {
+		return false
+	}
+
+	for key, value := range f.includeTags {
+		if tag, ok := member.Tags[key]; !ok || tag != value {
+			return false
+		}
+	}
+
+	for key, value := range f.excludeTags {
+		if tag, ok := member.Tags[key]; ok && tag == value {
+			return false
+		}
+	}
+
+	if len(f.nodeMeta) > 0 {
+		return f.matchesNodeMeta(member)
+	}
+
+	return true
+}
+
+// matchesNodeMeta returns true if the member node has the node metadata of the filter.
+// A member whose node can't be looked up is skipped.
+func (f *memberFilter) matchesNodeMeta(member *api.AgentMember) bool {
+	if f.metaNodes != nil {
+		_, ok := f.metaNodes[member.Name]
+		return ok
+	}
+
+	node, _, err := f.client.Catalog().Node(member.Name, nil)
+	if err != nil {
+		log.Error("Error looking up node of Agent '%s', skipping it: %s", member.Name, err.Error())
+		return false
+	}
+
+	if node == nil || node.Node == nil {
+		return false
+	}
+
+	for key, value := range f.nodeMeta {
+		if meta, ok := node.Node.Meta[key]; !ok || meta != value {
+			return false
+		}
+	}
+
+	return true
+}
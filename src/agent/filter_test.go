@@ -0,0 +1,233 @@
+package agent
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/newrelic/infra-integrations-sdk/v3/integration"
+	"github.com/newrelic/nri-consul/src/args"
+	"github.com/newrelic/nri-consul/src/testutils"
+)
+
+func TestCreateAgents_Filters(t *testing.T) {
+	mux, hostname, port, serverClose := testutils.SetupServer()
+	defer serverClose()
+
+	mux.HandleFunc("/v1/agent/members", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, membersFixture)
+	})
+
+	mux.HandleFunc("/v1/status/leader", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `"10.0.0.1:8300"`)
+	})
+
+	mux.HandleFunc("/v1/catalog/nodes", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("node-meta") != "kind:gateway" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `[
+			{
+				"Node": "gateway-0",
+				"Address": "10.0.0.4",
+				"Meta": {
+					"kind": "gateway"
+				}
+			}
+		]`)
+	})
+
+	testCases := []struct {
+		name string
+		arg  args.ArgumentList
+		want []string
+	}{
+		{
+			"No filters",
+			args.ArgumentList{},
+			[]string{"consul-0", "consul-1", "gateway-0", "web-0"},
+		},
+		{
+			"Servers only",
+			args.ArgumentList{FanOutServersOnly: true},
+			[]string{"consul-0", "consul-1"},
+		},
+		{
+			"Include name",
+			args.ArgumentList{FanOutIncludeName: "^web-"},
+			[]string{"consul-0", "web-0"},
+		},
+		{
+			"Exclude name",
+			args.ArgumentList{FanOutExcludeName: "^consul-"},
+			[]string{"consul-0", "gateway-0", "web-0"},
+		},
+		{
+			"Include tags",
+			args.ArgumentList{FanOutIncludeTags: "role=node,segment="},
+			[]string{"consul-0", "gateway-0"},
+		},
+		{
+			"Exclude tags",
+			args.ArgumentList{FanOutExcludeTags: "segment=alpha"},
+			[]string{"consul-0", "consul-1", "gateway-0"},
+		},
+		{
+			"Include node meta",
+			args.ArgumentList{FanOutIncludeNodeMeta: "kind=gateway"},
+			[]string{"consul-0", "gateway-0"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.arg.Hostname = hostname
+			tc.arg.Port = port
+			tc.arg.Timeout = "0s"
+
+			apiConfig, err := tc.arg.CreateAPIConfig(tc.arg.Hostname)
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+
+			client, err := api.NewClient(apiConfig)
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+
+			i, err := integration.New("test", "1.0.0")
+			if err != nil {
+				t.Fatalf("Unexpected error %s", err.Error())
+			}
+
+			agents, leader, err := CreateAgents(client, i, &tc.arg)
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+
+			if leader == nil || leader.name != "consul-0" {
+				t.Errorf("Leader was not correctly set: %+v", leader)
+			}
+
+			names := make([]string, 0, len(agents))
+			for _, agent := range agents {
+				names = append(names, agent.name)
+			}
+			sort.Strings(names)
+
+			if !reflect.DeepEqual(names, tc.want) {
+				t.Errorf("Expected agents %v got %v", tc.want, names)
+			}
+		})
+	}
+}
+
+func TestCreateAgents_NodeMetaLookupFailures(t *testing.T) {
+	mux, hostname, port, serverClose := testutils.SetupServer()
+	defer serverClose()
+
+	arg := args.ArgumentList{
+		Hostname:              hostname,
+		Port:                  port,
+		Timeout:               "0s",
+		FanOutIncludeNodeMeta: "kind=gateway",
+	}
+
+	apiConfig, err := arg.CreateAPIConfig(arg.Hostname)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	client, err := api.NewClient(apiConfig)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	i, err := integration.New("test", "1.0.0")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	mux.HandleFunc("/v1/agent/members", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, membersFixture)
+	})
+
+	mux.HandleFunc("/v1/status/leader", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `"10.0.0.1:8300"`)
+	})
+
+	// the nodes can't be listed at once so each member node is looked up, and web-0 fails
+	mux.HandleFunc("/v1/catalog/nodes", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	mux.HandleFunc("/v1/catalog/node/consul-1", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"Node": {"Node": "consul-1", "Meta": {"kind": "server"}}, "Services": {}}`)
+	})
+
+	mux.HandleFunc("/v1/catalog/node/web-0", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	mux.HandleFunc("/v1/catalog/node/gateway-0", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"Node": {"Node": "gateway-0", "Meta": {"kind": "gateway"}}, "Services": {}}`)
+	})
+
+	agents, _, err := CreateAgents(client, i, &arg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	names := make([]string, 0, len(agents))
+	for _, agent := range agents {
+		names = append(names, agent.name)
+	}
+	sort.Strings(names)
+
+	if want := []string{"consul-0", "gateway-0"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Expected agents %v got %v", want, names)
+	}
+}
+
+func TestCreateAgents_BadFilter(t *testing.T) {
+	mux, hostname, port, serverClose := testutils.SetupServer()
+	defer serverClose()
+
+	arg := args.ArgumentList{
+		Hostname:          hostname,
+		Port:              port,
+		Timeout:           "0s",
+		FanOutIncludeName: "(",
+	}
+
+	apiConfig, err := arg.CreateAPIConfig(arg.Hostname)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	client, err := api.NewClient(apiConfig)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	i, err := integration.New("test", "1.0.0")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	mux.HandleFunc("/v1/agent/members", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[]`)
+	})
+
+	mux.HandleFunc("/v1/status/leader", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `"10.0.0.1:8300"`)
+	})
+
+	if _, _, err := CreateAgents(client, i, &arg); err == nil {
+		t.Error("Expected error")
+	}
+}
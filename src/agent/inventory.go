@@ -4,12 +4,13 @@ import (
 	"sync"
 
 	"github.com/newrelic/infra-integrations-sdk/v3/log"
+	"github.com/newrelic/nri-consul/src/permissions"
 )
 
 // CollectInventory collects inventory data for each Agent entity
-func CollectInventory(agents []*Agent) {
+func CollectInventory(agents []*Agent, perms *permissions.Set) {
 	var wg sync.WaitGroup
-	agentChan := createInventoryPool(&wg)
+	agentChan := createInventoryPool(&wg, perms)
 
 	for _, agent := range agents {
 		agentChan <- agent
@@ -20,17 +21,17 @@ func CollectInventory(agents []*Agent) {
 	wg.Wait()
 }
 
-func createInventoryPool(wg *sync.WaitGroup) chan *Agent {
+func createInventoryPool(wg *sync.WaitGroup, perms *permissions.Set) chan *Agent {
 	agentChan := make(chan *Agent)
 	wg.Add(workerCount)
 	for i := 0; i < workerCount; i++ {
-		go inventoryWorker(agentChan, wg)
+		go inventoryWorker(agentChan, wg, perms)
 	}
 
 	return agentChan
 }
 
-func inventoryWorker(agentChan <-chan *Agent, wg *sync.WaitGroup) {
+func inventoryWorker(agentChan <-chan *Agent, wg *sync.WaitGroup, perms *permissions.Set) {
 	defer wg.Done()
 
 	for {
@@ -39,12 +40,16 @@ func inventoryWorker(agentChan <-chan *Agent, wg *sync.WaitGroup) {
 			return
 		}
 
-		CollectInventoryFromOne(agent)
+		CollectInventoryFromOne(agent, perms)
 	}
 }
 
-// CollectInventoryFromOne collects inventory data for a single agent entity
-func CollectInventoryFromOne(agent *Agent) {
+// CollectInventoryFromOne collects inventory data for a single agent entity, unless perms doesn't allow it
+func CollectInventoryFromOne(agent *Agent, perms *permissions.Set) {
+	if !agent.Permissions(perms).Allowed(permissions.AgentInventory) {
+		return
+	}
+
 	selfData, err := agent.Client.Agent().Self()
 	if err != nil {
 		log.Error("Error retrieving self configuration data for Agent '%s': %s", agent.entity.Metadata.Name, err.Error())
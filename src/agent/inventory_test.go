@@ -112,7 +112,7 @@ func TestCollectInventory_AllValid(t *testing.T) {
 
 	doneChan := make(chan bool)
 	go func() {
-		CollectInventory(agents)
+		CollectInventory(agents, nil)
 		close(doneChan)
 	}()
 
@@ -222,7 +222,7 @@ func TestCollectInventory_BadArray(t *testing.T) {
 
 	doneChan := make(chan bool)
 	go func() {
-		CollectInventory(agents)
+		CollectInventory(agents, nil)
 		close(doneChan)
 	}()
 
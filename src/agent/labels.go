@@ -0,0 +1,149 @@
+package agent
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/data/attribute"
+	"github.com/newrelic/infra-integrations-sdk/v3/data/metric"
+	"github.com/newrelic/nri-consul/src/metrics"
+)
+
+// labelAttributePrefix keeps label attributes from overriding the common ones, e.g. the datacenter label
+const labelAttributePrefix = "label."
+
+// labeledSets holds the metric set of each label combination found during a core metrics collection.
+// Series without any of the definition labels go to the main set.
+type labeledSets struct {
+	main         *metric.Set
+	newMetricSet metrics.MetricSetFactory
+	sets         map[string]*metric.Set
+}
+
+func newLabeledSets(main *metric.Set, newMetricSet metrics.MetricSetFactory) *labeledSets {
+	return &labeledSets{
+		main:         main,
+		newMetricSet: newMetricSet,
+		sets:         make(map[string]*metric.Set),
+	}
+}
+
+// get returns the metric set of a label combination, creating it the first time
+func (ls *labeledSets) get(labels map[string]string) *metric.Set {
+	if len(labels) == 0 {
+		return ls.main
+	}
+
+	key := labelsKey(labels)
+	if set, ok := ls.sets[key]; ok {
+		return set
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	attributes := make([]attribute.Attribute, 0, len(names))
+	for _, name := range names {
+		attributes = append(attributes, attribute.Attribute{Key: labelAttributePrefix + name, Value: labels[name]})
+	}
+
+	set := ls.newMetricSet(attributes...)
+	ls.sets[key] = set
+	return set
+}
+
+// labelGroup is a label combination and the index of the series merged into it
+type labelGroup struct {
+	labels  map[string]string
+	indexes []int
+}
+
+// groupByLabels groups the series of a metric by the values of the definition labels,
+// keeping the order they were found in. Other labels are ignored so their series are merged.
+func groupByLabels(seriesLabels []map[string]string, labelNames []string) []*labelGroup {
+	var groups []*labelGroup
+	byKey := make(map[string]*labelGroup)
+
+	for idx, series := range seriesLabels {
+		labels := projectLabels(series, labelNames)
+		key := labelsKey(labels)
+
+		group, ok := byKey[key]
+		if !ok {
+			group = &labelGroup{labels: labels}
+			byKey[key] = group
+			groups = append(groups, group)
+		}
+		group.indexes = append(group.indexes, idx)
+	}
+
+	return groups
+}
+
+// projectLabels keeps the labels listed by the definition
+func projectLabels(labels map[string]string, names []string) map[string]string {
+	projected := make(map[string]string)
+	for _, name := range names {
+		if value, ok := labels[name]; ok {
+			projected[name] = value
+		}
+	}
+
+	return projected
+}
+
+// labelsKey identifies a label combination
+func labelsKey(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for name, value := range labels {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
+
+// mergeSamples combines samples of the same interval as if they were recorded as one series.
+// The standard deviation is recombined from the sum of squares each sample implies.
+func mergeSamples(samples []sampledValue) sampledValue {
+	merged := samples[0]
+	if len(samples) == 1 {
+		return merged
+	}
+
+	sumSq := sumOfSquares(merged)
+	for _, sample := range samples[1:] {
+		merged.Count += sample.Count
+		merged.Sum += sample.Sum
+		merged.Rate += sample.Rate
+		merged.Min = math.Min(merged.Min, sample.Min)
+		merged.Max = math.Max(merged.Max, sample.Max)
+		sumSq += sumOfSquares(sample)
+	}
+
+	merged.Labels = nil
+	merged.Mean, merged.Stddev = 0, 0
+	if merged.Count > 0 {
+		merged.Mean = merged.Sum / float64(merged.Count)
+	}
+	if merged.Count > 1 {
+		count := float64(merged.Count)
+		merged.Stddev = math.Sqrt(math.Max(0, (sumSq-merged.Sum*merged.Sum/count)/(count-1)))
+	}
+
+	return merged
+}
+
+// sumOfSquares returns the sum of the squared values of a sample, from its sample standard deviation
+func sumOfSquares(sample sampledValue) float64 {
+	if sample.Count == 0 {
+		return 0
+	}
+
+	count := float64(sample.Count)
+	return sample.Stddev*sample.Stddev*(count-1) + sample.Sum*sample.Sum/count
+}
@@ -0,0 +1,156 @@
+package agent
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/newrelic/infra-integrations-sdk/v3/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/v3/integration"
+	"github.com/newrelic/nri-consul/src/args"
+	"github.com/newrelic/nri-consul/src/metrics"
+	"github.com/newrelic/nri-consul/src/testutils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectCoreMetrics_Labels(t *testing.T) {
+	mux, hostname, port, serverClose := testutils.SetupServer()
+	defer serverClose()
+
+	arg := args.ArgumentList{
+		Hostname:  hostname,
+		Port:      port,
+		EnableSSL: false,
+		Timeout:   "0s",
+	}
+
+	apiConfig, err := arg.CreateAPIConfig(arg.Hostname)
+	require.NoError(t, err)
+
+	client, err := api.NewClient(apiConfig)
+	require.NoError(t, err)
+
+	i, err := integration.New("test", "1.0.0")
+	require.NoError(t, err)
+
+	entity, err := i.Entity("test", "agent")
+	require.NoError(t, err)
+
+	agent := NewAgent(client, entity, "consul-0", "10.0.0.1", "8301", "dc1", "")
+
+	mux.HandleFunc("/v1/agent/metrics", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{
+			"Gauges": [
+				{"Name": "consul.state.services", "Value": 3, "Labels": {"namespace": "default"}},
+				{"Name": "consul.state.services", "Value": 2, "Labels": {"namespace": "team"}}
+			],
+			"Counters": [
+				{"Name": "consul.client.rpc.failed", "Count": 1, "Rate": 0.1, "Sum": 1, "Labels": {"server": "consul-0"}},
+				{"Name": "consul.client.rpc.failed", "Count": 3, "Rate": 0.3, "Sum": 3, "Labels": {"server": "consul-1"}},
+				{"Name": "consul.client.rpc.failed", "Count": 1, "Rate": 0.1, "Sum": 1, "Labels": {}}
+			],
+			"Samples": [
+				{"Name": "consul.rpc.request", "Count": 2, "Rate": 0.6, "Sum": 6, "Min": 2, "Max": 4, "Mean": 3, "Stddev": 1.4142135623730951, "Labels": {"method": "Catalog.Register", "errored": "false"}},
+				{"Name": "consul.rpc.request", "Count": 1, "Rate": 1, "Sum": 10, "Min": 10, "Max": 10, "Mean": 10, "Stddev": 0, "Labels": {"method": "Catalog.Register", "errored": "true"}},
+				{"Name": "consul.rpc.request", "Count": 1, "Rate": 0.1, "Sum": 1, "Min": 1, "Max": 1, "Mean": 1, "Stddev": 0, "Labels": {"method": "Health.ServiceNodes", "errored": "false"}}
+			]
+		}`)
+	})
+
+	gaugeDefs := []*metrics.MetricDefinition{
+		{APIKey: "consul.state.services", MetricName: "state.services", SourceType: metric.GAUGE},
+		{APIKey: "consul.state.services", MetricName: "state.namespaceServices", SourceType: metric.GAUGE, Labels: []string{"namespace"}},
+	}
+	counterDefs := []*metrics.MetricDefinition{
+		{APIKey: "consul.client.rpc.failed", MetricName: "client.rpcFailed", SourceType: metric.GAUGE, Labels: []string{"server"}},
+	}
+	timerDefs := []*metrics.TimerDefinition{
+		{
+			MetricDefinition: metrics.MetricDefinition{APIKey: "consul.rpc.request", MetricName: "rpc.requestMaxInMilliseconds", SourceType: metric.GAUGE, Labels: []string{"method"}},
+			Operation:        metrics.Max,
+		},
+		{
+			MetricDefinition: metrics.MetricDefinition{APIKey: "consul.rpc.request", MetricName: "rpc.requestAvgInMilliseconds", SourceType: metric.GAUGE, Labels: []string{"method"}},
+			Operation:        metrics.Average,
+		},
+	}
+
+	metricSet := agent.newMetricSet()
+	require.NoError(t, agent.CollectCoreMetrics(metricSet, agent.newMetricSet, gaugeDefs, counterDefs, timerDefs))
+
+	common := map[string]interface{}{
+		"event_type":  "ConsulAgentSample",
+		"displayName": "test",
+		"entityName":  "agent:test",
+		"ip":          "10.0.0.1",
+		"port":        "8301",
+		"datacenter":  "dc1",
+	}
+	with := func(values map[string]interface{}) map[string]interface{} {
+		for k, v := range common {
+			values[k] = v
+		}
+		return values
+	}
+
+	expected := []map[string]interface{}{
+		// unlabeled gauges keep the first series, counters merge every series without the definition labels
+		with(map[string]interface{}{
+			"state.services":   float64(3),
+			"client.rpcFailed": float64(0.1),
+		}),
+		with(map[string]interface{}{
+			"label.namespace":         "default",
+			"state.namespaceServices": float64(3),
+		}),
+		with(map[string]interface{}{
+			"label.namespace":         "team",
+			"state.namespaceServices": float64(2),
+		}),
+		with(map[string]interface{}{
+			"label.server":     "consul-0",
+			"client.rpcFailed": float64(0.1),
+		}),
+		with(map[string]interface{}{
+			"label.server":     "consul-1",
+			"client.rpcFailed": float64(0.3),
+		}),
+		// series only differing in the errored label are merged
+		with(map[string]interface{}{
+			"label.method":                 "Catalog.Register",
+			"rpc.requestMaxInMilliseconds": float64(10),
+			"rpc.requestAvgInMilliseconds": float64(16) / 3,
+		}),
+		with(map[string]interface{}{
+			"label.method":                 "Health.ServiceNodes",
+			"rpc.requestMaxInMilliseconds": float64(1),
+			"rpc.requestAvgInMilliseconds": float64(1),
+		}),
+	}
+
+	require.Len(t, entity.Metrics, len(expected))
+	for idx, want := range expected {
+		require.Equal(t, want, entity.Metrics[idx].Metrics)
+	}
+}
+
+func Test_mergeSamples(t *testing.T) {
+	// 2, 4 and 10 recorded as two series
+	merged := mergeSamples([]sampledValue{
+		{SampledValue: api.SampledValue{Name: "consul.rpc.request", Count: 2, Sum: 6, Min: 2, Max: 4, Mean: 3, Stddev: math.Sqrt2, Labels: map[string]string{"errored": "false"}}, Rate: 0.6},
+		{SampledValue: api.SampledValue{Name: "consul.rpc.request", Count: 1, Sum: 10, Min: 10, Max: 10, Mean: 10, Labels: map[string]string{"errored": "true"}}, Rate: 1},
+	})
+
+	require.Equal(t, "consul.rpc.request", merged.Name)
+	require.Equal(t, 3, merged.Count)
+	require.Equal(t, float64(16), merged.Sum)
+	require.InDelta(t, 1.6, merged.Rate, 1e-9)
+	require.Equal(t, float64(2), merged.Min)
+	require.Equal(t, float64(10), merged.Max)
+	require.InDelta(t, float64(16)/3, merged.Mean, 1e-9)
+	// sample standard deviation of 2, 4 and 10
+	require.InDelta(t, math.Sqrt(float64(52)/3), merged.Stddev, 1e-9)
+	require.Nil(t, merged.Labels)
+}
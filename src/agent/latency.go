@@ -26,28 +26,28 @@ func calculateLatencyMetrics(metricSet *metric.Set, node *api.CoordinateEntry, n
 			continue
 		}
 
-		latencies = append(latencies, calcLatencyDist(node.Coord, other.Coord))
+		latencies = append(latencies, CalcLatencyDist(node.Coord, other.Coord))
 	}
 
 	// Sort latencies
 	sort.Float64s(latencies)
 
 	// Set metrics
-	metrics.SetMetric(metricSet, "net.agent.medianLatencyInMilliseconds", calcLatencyMedian(latencies), metric.GAUGE)
+	metrics.SetMetric(metricSet, "net.agent.medianLatencyInMilliseconds", CalcLatencyMedian(latencies), metric.GAUGE)
 	metrics.SetMetric(metricSet, "net.agent.minLatencyInMilliseconds", latencies[0], metric.GAUGE)
 	metrics.SetMetric(metricSet, "net.agent.maxLatencyInMilliseconds", latencies[len(latencies)-1], metric.GAUGE)
-	metrics.SetMetric(metricSet, "net.agent.p25LatencyInMilliseconds", calcLatencyPercentile(latencies, 0.25), metric.GAUGE)
-	metrics.SetMetric(metricSet, "net.agent.p75LatencyInMilliseconds", calcLatencyPercentile(latencies, 0.75), metric.GAUGE)
-	metrics.SetMetric(metricSet, "net.agent.p90LatencyInMilliseconds", calcLatencyPercentile(latencies, 0.90), metric.GAUGE)
-	metrics.SetMetric(metricSet, "net.agent.p95LatencyInMilliseconds", calcLatencyPercentile(latencies, 0.95), metric.GAUGE)
-	metrics.SetMetric(metricSet, "net.agent.p99LatencyInMilliseconds", calcLatencyPercentile(latencies, 0.99), metric.GAUGE)
+	metrics.SetMetric(metricSet, "net.agent.p25LatencyInMilliseconds", CalcLatencyPercentile(latencies, 0.25), metric.GAUGE)
+	metrics.SetMetric(metricSet, "net.agent.p75LatencyInMilliseconds", CalcLatencyPercentile(latencies, 0.75), metric.GAUGE)
+	metrics.SetMetric(metricSet, "net.agent.p90LatencyInMilliseconds", CalcLatencyPercentile(latencies, 0.90), metric.GAUGE)
+	metrics.SetMetric(metricSet, "net.agent.p95LatencyInMilliseconds", CalcLatencyPercentile(latencies, 0.95), metric.GAUGE)
+	metrics.SetMetric(metricSet, "net.agent.p99LatencyInMilliseconds", CalcLatencyPercentile(latencies, 0.99), metric.GAUGE)
 }
 
-// calcLatencyDist calculates distance between two coordinates.
+// CalcLatencyDist calculates distance between two coordinates.
 // Taken from Consul docs https://www.consul.io/docs/internals/coordinates.html
 // In order to compute the latency between two nodes you must calculate
 // the distance based on their coordinates.
-func calcLatencyDist(a, b *coordinate.Coordinate) float64 {
+func CalcLatencyDist(a, b *coordinate.Coordinate) float64 {
 	// Calculate the Euclidean distance plus the heights.
 	sumsq := 0.0
 	for i := 0; i < len(a.Vec); i++ {
@@ -65,8 +65,8 @@ func calcLatencyDist(a, b *coordinate.Coordinate) float64 {
 	return rtt * 1000.0
 }
 
-// calcLatencyMedian is the median of a data set of latencies
-func calcLatencyMedian(latencies []float64) float64 {
+// CalcLatencyMedian is the median of a data set of latencies
+func CalcLatencyMedian(latencies []float64) float64 {
 	numLatencies := len(latencies)
 	halfIndex := numLatencies / 2
 
@@ -77,7 +77,8 @@ func calcLatencyMedian(latencies []float64) float64 {
 	return latencies[halfIndex]
 }
 
-func calcLatencyPercentile(latencies []float64, percent float64) float64 {
+// CalcLatencyPercentile is the given percentile of a sorted data set of latencies
+func CalcLatencyPercentile(latencies []float64, percent float64) float64 {
 	numLatencies := float64(len(latencies))
 	index := int(math.Ceil(numLatencies * percent))
 	return latencies[index-1]
@@ -0,0 +1,32 @@
+package agent
+
+import (
+	"github.com/hashicorp/consul/api"
+)
+
+// Serf member statuses, as defined by github.com/hashicorp/serf/serf.MemberStatus
+const (
+	MemberStatusNone    = "none"
+	MemberStatusAlive   = "alive"
+	MemberStatusLeaving = "leaving"
+	MemberStatusLeft    = "left"
+	MemberStatusFailed  = "failed"
+)
+
+// memberStatuses maps the numeric serf status returned by the members endpoint to its name
+var memberStatuses = []string{
+	MemberStatusNone,
+	MemberStatusAlive,
+	MemberStatusLeaving,
+	MemberStatusLeft,
+	MemberStatusFailed,
+}
+
+// MemberStatus returns the name of the serf status of a member
+func MemberStatus(member *api.AgentMember) string {
+	if member.Status < 0 || member.Status >= len(memberStatuses) {
+		return MemberStatusNone
+	}
+
+	return memberStatuses[member.Status]
+}
@@ -4,13 +4,16 @@ import (
 	"sync"
 
 	"github.com/newrelic/infra-integrations-sdk/v3/data/attribute"
+	"github.com/newrelic/infra-integrations-sdk/v3/data/metric"
 	"github.com/newrelic/infra-integrations-sdk/v3/log"
+	"github.com/newrelic/nri-consul/src/metrics"
+	"github.com/newrelic/nri-consul/src/permissions"
 )
 
-// CollectMetrics does a metric collect for a group of agents
-func CollectMetrics(agents []*Agent) {
+// CollectMetrics does a metric collect for a group of agents, reading their telemetry into defs
+func CollectMetrics(agents []*Agent, perms *permissions.Set, defs *metrics.Definitions) {
 	var wg sync.WaitGroup
-	agentChan := createMetricPool(&wg)
+	agentChan := createMetricPool(&wg, perms, defs)
 
 	for _, agent := range agents {
 		agentChan <- agent
@@ -21,17 +24,17 @@ func CollectMetrics(agents []*Agent) {
 	wg.Wait()
 }
 
-func createMetricPool(wg *sync.WaitGroup) chan *Agent {
+func createMetricPool(wg *sync.WaitGroup, perms *permissions.Set, defs *metrics.Definitions) chan *Agent {
 	agentChan := make(chan *Agent)
 	wg.Add(workerCount)
 	for i := 0; i < workerCount; i++ {
-		go metricWorker(agentChan, wg)
+		go metricWorker(agentChan, wg, perms, defs)
 	}
 
 	return agentChan
 }
 
-func metricWorker(agentChan <-chan *Agent, wg *sync.WaitGroup) {
+func metricWorker(agentChan <-chan *Agent, wg *sync.WaitGroup, perms *permissions.Set, defs *metrics.Definitions) {
 	defer wg.Done()
 
 	for {
@@ -40,33 +43,58 @@ func metricWorker(agentChan <-chan *Agent, wg *sync.WaitGroup) {
 			return
 		}
 
-		CollectMetricsFromOne(agent)
+		CollectMetricsFromOne(agent, perms, defs)
 
 	}
 }
 
-// CollectMetricsFromOne does a metric collect for a single agent
-func CollectMetricsFromOne(agent *Agent) {
-	metricSet := agent.entity.NewMetricSet("ConsulAgentSample",
-		attribute.Attribute{Key: "displayName", Value: agent.entity.Metadata.Name},
-		attribute.Attribute{Key: "entityName", Value: agent.entity.Metadata.Namespace + ":" + agent.entity.Metadata.Name},
-		attribute.Attribute{Key: "ip", Value: agent.ipAddr},
-		attribute.Attribute{Key: "datacenter", Value: agent.datacenter},
-	)
+// CollectMetricsFromOne does a metric collect for a single agent, skipping the collectors perms doesn't allow
+func CollectMetricsFromOne(agent *Agent, perms *permissions.Set, defs *metrics.Definitions) {
+	perms = agent.Permissions(perms)
+	metricSet := agent.newMetricSet()
 
 	// Collect core metrics
-	if err := agent.CollectCoreMetrics(metricSet, gaugeMetrics, counterMetrics, timerMetrics); err != nil {
-		log.Error("Error collecting core metrics for Agent '%s': %s", agent.entity.Metadata.Name, err.Error())
+	if perms.Allowed(permissions.AgentCoreMetrics) {
+		if err := agent.CollectCoreMetrics(metricSet, agent.newMetricSet, defs.Gauges, defs.Counters, defs.Timers); err != nil {
+			log.Error("Error collecting core metrics for Agent '%s': %s", agent.entity.Metadata.Name, err.Error())
+		}
 	}
 
 	// Peer Count
-	if err := agent.collectPeerCount(metricSet); err != nil {
-		log.Error("Error collecting peer count for Agent '%s': %s", agent.entity.Metadata.Name, err.Error())
+	if perms.Allowed(permissions.AgentPeers) {
+		if err := agent.collectPeerCount(metricSet); err != nil {
+			log.Error("Error collecting peer count for Agent '%s': %s", agent.entity.Metadata.Name, err.Error())
+		}
 	}
 
 	// Latency metrics
-	if err := agent.collectLatencyMetrics(metricSet); err != nil {
-		log.Error("Error collecting latency metrics for Agent '%s': %s", agent.entity.Metadata.Name, err.Error())
+	if perms.Allowed(permissions.AgentLatency) {
+		if err := agent.collectLatencyMetrics(metricSet, perms); err != nil {
+			log.Error("Error collecting latency metrics for Agent '%s': %s", agent.entity.Metadata.Name, err.Error())
+		}
+	}
+
+}
+
+// setUnreachableMetrics marks an agent that won't be queried because of its serf status
+func (a *Agent) setUnreachableMetrics(serfStatus string) {
+	metricSet := a.newMetricSet(attribute.Attribute{Key: "serfStatus", Value: serfStatus})
+	metrics.SetMetric(metricSet, "agent.unreachable", 1, metric.GAUGE)
+}
+
+// newMetricSet creates a ConsulAgentSample with the attributes common to every agent
+func (a *Agent) newMetricSet(extraAttributes ...attribute.Attribute) *metric.Set {
+	attributes := []attribute.Attribute{
+		{Key: "displayName", Value: a.entity.Metadata.Name},
+		{Key: "entityName", Value: a.entity.Metadata.Namespace + ":" + a.entity.Metadata.Name},
+		{Key: "ip", Value: a.ipAddr},
+		{Key: "port", Value: a.port},
+		{Key: "datacenter", Value: a.datacenter},
+	}
+
+	if a.clusterName != "" {
+		attributes = append(attributes, attribute.Attribute{Key: "clusterName", Value: a.clusterName})
 	}
 
+	return a.entity.NewMetricSet("ConsulAgentSample", append(attributes, extraAttributes...)...)
 }
@@ -48,6 +48,7 @@ func TestCollectMetrics_CoreMetrics(t *testing.T) {
 		entity:     entity,
 		datacenter: "MyDC",
 		ipAddr:     "192.168.0.0",
+		port:       "8301",
 	}
 
 	agents := []*Agent{agent}
@@ -110,12 +111,12 @@ func TestCollectMetrics_CoreMetrics(t *testing.T) {
 				{
 					"Name": "consul.txn.apply",
 					"Count": 1,
-					"Rate": 0.16021829843521118,
+					"Rate": 0.5,
 					"Sum": 5,
 					"Min": 1,
 					"Max": 5,
 					"Mean": 3,
-					"Stddev": 0,
+					"Stddev": 2,
 					"Labels": {}
 				}
 			]
@@ -128,6 +129,7 @@ func TestCollectMetrics_CoreMetrics(t *testing.T) {
 		"entityName":                         agent.entity.Metadata.Namespace + ":" + agent.entity.Metadata.Name,
 		"datacenter":                         agent.datacenter,
 		"ip":                                 agent.ipAddr,
+		"port":                               agent.port,
 		"runtime.goroutines":                 float64(49),
 		"runtime.heapObjects":                float64(33463),
 		"runtime.virtualAddressSpaceInBytes": float64(14395640),
@@ -135,13 +137,17 @@ func TestCollectMetrics_CoreMetrics(t *testing.T) {
 		"runtime.frees":                      float64(115177384),
 		"runtime.gcPauseInMilliseconds":      float64(679636350) / 1000000,
 		"runtime.gcCycles":                   float64(24701),
-		"agent.aclCacheHit":                  float64(0),
+		"agent.aclCacheHit":                  float64(0.2),
 		"agent.txnAvgInMilliseconds":         float64(3),
-		"agent.txns":                         float64(0),
+		"agent.txns":                         float64(0.1),
 		"agent.txnMaxInMilliseconds":         float64(5),
+		"agent.txnMinInMilliseconds":         float64(1),
+		"agent.txnStddevInMilliseconds":      float64(2),
+		"agent.txnSumInMilliseconds":         float64(5),
+		"agent.txnInMillisecondsPerSecond":   float64(0.5),
 	}
 
-	CollectMetrics(agents)
+	CollectMetrics(agents, nil, BuiltInDefinitions())
 
 	result := agent.entity.Metrics[0].Metrics
 	if !reflect.DeepEqual(result, expected) {
@@ -185,6 +191,7 @@ func TestCollectMetrics_PeerMetrics(t *testing.T) {
 		entity:     entity,
 		datacenter: "MyDC",
 		ipAddr:     "192.168.0.0",
+		port:       "8301",
 	}
 
 	agents := []*Agent{agent}
@@ -203,10 +210,11 @@ func TestCollectMetrics_PeerMetrics(t *testing.T) {
 		"entityName":  agent.entity.Metadata.Namespace + ":" + agent.entity.Metadata.Name,
 		"datacenter":  agent.datacenter,
 		"ip":          agent.ipAddr,
+		"port":        agent.port,
 		"agent.peers": float64(3),
 	}
 
-	CollectMetrics(agents)
+	CollectMetrics(agents, nil, BuiltInDefinitions())
 
 	result := agent.entity.Metrics[0].Metrics
 	if !reflect.DeepEqual(result, expected) {
@@ -323,6 +331,7 @@ func TestCollectMetrics_LatencyMetrics(t *testing.T) {
 		"event_type":  "ConsulAgentSample",
 		"datacenter":  agent.datacenter,
 		"ip":          agent.ipAddr,
+		"port":        agent.port,
 
 		"net.agent.medianLatencyInMilliseconds": 0.3303747050428994,
 		"net.agent.minLatencyInMilliseconds":    0.28994299609053836,
@@ -334,7 +343,7 @@ func TestCollectMetrics_LatencyMetrics(t *testing.T) {
 		"net.agent.p99LatencyInMilliseconds":    0.453482732462,
 	}
 
-	CollectMetrics(agents)
+	CollectMetrics(agents, nil, BuiltInDefinitions())
 
 	result := agent.entity.Metrics[0].Metrics
 	if !reflect.DeepEqual(result, expected) {
@@ -48,36 +48,45 @@ var gaugeMetrics = []*metrics.MetricDefinition{
 	},
 }
 
+// counterMetrics are reported per second. Counters reset every telemetry interval so
+// they are gauges, rating them would take the delta of unrelated intervals.
 var counterMetrics = []*metrics.MetricDefinition{
 	{
 		APIKey:     "consul.client.rpc",
 		MetricName: "client.rpcLoad",
-		SourceType: metric.RATE,
+		SourceType: metric.GAUGE,
 	},
 	{
 		APIKey:     "consul.client.rpc.exceeded",
 		MetricName: "client.rpcRateLimited",
-		SourceType: metric.RATE,
+		SourceType: metric.GAUGE,
 	},
 	{
 		APIKey:     "consul.client.rpc.failed",
 		MetricName: "client.rpcFailed",
-		SourceType: metric.RATE,
+		SourceType: metric.GAUGE,
+		Labels:     []string{"server"},
 	},
 	{
 		APIKey:     "consul.acl.cache_hit",
 		MetricName: "agent.aclCacheHit",
-		SourceType: metric.RATE,
+		SourceType: metric.GAUGE,
 	},
 	{
 		APIKey:     "consul.acl.cache_miss",
 		MetricName: "agent.aclCacheMiss",
-		SourceType: metric.RATE,
+		SourceType: metric.GAUGE,
 	},
 	{
 		APIKey:     "consul.dns.stale_queries",
 		MetricName: "agent.staleQueries",
-		SourceType: metric.RATE,
+		SourceType: metric.GAUGE,
+	},
+	{
+		APIKey:     "consul.rpc.request",
+		MetricName: "agent.rpcRequests",
+		SourceType: metric.GAUGE,
+		Labels:     []string{"method"},
 	},
 }
 
@@ -94,9 +103,9 @@ var timerMetrics = []*metrics.TimerDefinition{
 		MetricDefinition: metrics.MetricDefinition{
 			APIKey:     "consul.txn.apply",
 			MetricName: "agent.txns",
-			SourceType: metric.RATE,
+			SourceType: metric.GAUGE,
 		},
-		Operation: metrics.Count,
+		Operation: metrics.CountPerSecond,
 	},
 	{
 		MetricDefinition: metrics.MetricDefinition{
@@ -106,6 +115,38 @@ var timerMetrics = []*metrics.TimerDefinition{
 		},
 		Operation: metrics.Max,
 	},
+	{
+		MetricDefinition: metrics.MetricDefinition{
+			APIKey:     "consul.txn.apply",
+			MetricName: "agent.txnMinInMilliseconds",
+			SourceType: metric.GAUGE,
+		},
+		Operation: metrics.Min,
+	},
+	{
+		MetricDefinition: metrics.MetricDefinition{
+			APIKey:     "consul.txn.apply",
+			MetricName: "agent.txnStddevInMilliseconds",
+			SourceType: metric.GAUGE,
+		},
+		Operation: metrics.Stddev,
+	},
+	{
+		MetricDefinition: metrics.MetricDefinition{
+			APIKey:     "consul.txn.apply",
+			MetricName: "agent.txnSumInMilliseconds",
+			SourceType: metric.GAUGE,
+		},
+		Operation: metrics.Sum,
+	},
+	{
+		MetricDefinition: metrics.MetricDefinition{
+			APIKey:     "consul.txn.apply",
+			MetricName: "agent.txnInMillisecondsPerSecond",
+			SourceType: metric.GAUGE,
+		},
+		Operation: metrics.Rate,
+	},
 	{
 		MetricDefinition: metrics.MetricDefinition{
 			APIKey:     "consul.kvs.apply",
@@ -118,9 +159,9 @@ var timerMetrics = []*metrics.TimerDefinition{
 		MetricDefinition: metrics.MetricDefinition{
 			APIKey:     "consul.kvs.apply",
 			MetricName: "agent.kvStoress",
-			SourceType: metric.RATE,
+			SourceType: metric.GAUGE,
 		},
-		Operation: metrics.Count,
+		Operation: metrics.CountPerSecond,
 	},
 	{
 		MetricDefinition: metrics.MetricDefinition{
@@ -130,4 +171,58 @@ var timerMetrics = []*metrics.TimerDefinition{
 		},
 		Operation: metrics.Max,
 	},
+	{
+		MetricDefinition: metrics.MetricDefinition{
+			APIKey:     "consul.kvs.apply",
+			MetricName: "agent.kvStoresMinInMilliseconds",
+			SourceType: metric.GAUGE,
+		},
+		Operation: metrics.Min,
+	},
+	{
+		MetricDefinition: metrics.MetricDefinition{
+			APIKey:     "consul.kvs.apply",
+			MetricName: "agent.kvStoresStddevInMilliseconds",
+			SourceType: metric.GAUGE,
+		},
+		Operation: metrics.Stddev,
+	},
+	{
+		MetricDefinition: metrics.MetricDefinition{
+			APIKey:     "consul.kvs.apply",
+			MetricName: "agent.kvStoresSumInMilliseconds",
+			SourceType: metric.GAUGE,
+		},
+		Operation: metrics.Sum,
+	},
+	{
+		MetricDefinition: metrics.MetricDefinition{
+			APIKey:     "consul.kvs.apply",
+			MetricName: "agent.kvStoresInMillisecondsPerSecond",
+			SourceType: metric.GAUGE,
+		},
+		Operation: metrics.Rate,
+	},
+}
+
+// BuiltInDefinitions returns the metric definitions collected into ConsulAgentSample by default
+func BuiltInDefinitions() *metrics.Definitions {
+	return &metrics.Definitions{Gauges: gaugeMetrics, Counters: counterMetrics, Timers: timerMetrics}
+}
+
+// ReservedNames returns the attribute and metric names ConsulAgentSample reports besides its metric definitions
+func ReservedNames() []string {
+	return []string{
+		"event_type", "displayName", "entityName", "ip", "port", "datacenter", "clusterName", "serfStatus",
+		"agent.unreachable",
+		"agent.peers",
+		"net.agent.medianLatencyInMilliseconds",
+		"net.agent.minLatencyInMilliseconds",
+		"net.agent.maxLatencyInMilliseconds",
+		"net.agent.p25LatencyInMilliseconds",
+		"net.agent.p75LatencyInMilliseconds",
+		"net.agent.p90LatencyInMilliseconds",
+		"net.agent.p95LatencyInMilliseconds",
+		"net.agent.p99LatencyInMilliseconds",
+	}
 }
@@ -0,0 +1,27 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/data/metric"
+	"github.com/newrelic/nri-consul/src/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuiltInDefinitions_Extend(t *testing.T) {
+	builtInGauges := len(gaugeMetrics)
+
+	services := &metrics.MetricDefinition{APIKey: "consul.consul.state.services", MetricName: "agent.services", SourceType: metric.GAUGE}
+	defs, err := BuiltInDefinitions().Extend(&metrics.Definitions{Gauges: []*metrics.MetricDefinition{services}}, ReservedNames())
+	require.NoError(t, err)
+	require.Equal(t, services, defs.Gauges[len(defs.Gauges)-1])
+	require.Equal(t, builtInGauges+1, len(defs.Gauges))
+	require.Equal(t, builtInGauges, len(gaugeMetrics))
+
+	// neither a built-in metric name nor a name the collectors report can be redefined
+	for _, name := range []string{gaugeMetrics[0].MetricName, "agent.peers", "net.agent.p99LatencyInMilliseconds", "ip"} {
+		duplicated := &metrics.MetricDefinition{APIKey: "consul.rpc.queries_blocking", MetricName: name, SourceType: metric.GAUGE}
+		_, err := BuiltInDefinitions().Extend(&metrics.Definitions{Counters: []*metrics.MetricDefinition{duplicated}}, ReservedNames())
+		require.Error(t, err, name)
+	}
+}
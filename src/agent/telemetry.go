@@ -0,0 +1,170 @@
+package agent
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/newrelic/infra-integrations-sdk/v3/log"
+	"github.com/newrelic/nri-consul/src/metrics"
+)
+
+// defaultMetricsPrefix is the Consul default metrics_prefix, used by the metric definitions
+const defaultMetricsPrefix = "consul"
+
+// telemetry is the part of the agent telemetry configuration that shapes the metric names
+type telemetry struct {
+	prefix          string
+	disableHostname bool
+	hostname        string
+	// denied is set when the token can't read the configuration, see matchDefinition
+	denied bool
+}
+
+// getTelemetry reads the telemetry configuration of the agent once
+func (a *Agent) getTelemetry() *telemetry {
+	a.telemetryOnce.Do(func() {
+		a.telemetry = readTelemetry(a.Client)
+	})
+
+	return a.telemetry
+}
+
+// readTelemetry reads the telemetry configuration from the agent self endpoint, falling back to
+// the Consul defaults. Go-metrics uses the OS hostname, which is assumed to be the node name.
+func readTelemetry(client *api.Client) *telemetry {
+	t := &telemetry{
+		prefix: defaultMetricsPrefix,
+	}
+
+	self, err := client.Agent().Self()
+	var statusErr api.StatusError
+	if errors.As(err, &statusErr) && statusErr.Code == http.StatusForbidden {
+		log.Debug("Telemetry configuration can't be read, matching series against the metric definitions: %s", statusErr.Body)
+		t.denied = true
+		return t
+	}
+
+	if err != nil {
+		log.Warn("Error reading telemetry configuration, assuming the defaults: %s", err.Error())
+		return t
+	}
+
+	if nodeName, ok := self["Config"]["NodeName"].(string); ok {
+		t.hostname = nodeName
+	}
+
+	config, ok := self["DebugConfig"]["Telemetry"].(map[string]interface{})
+	if !ok {
+		log.Debug("Telemetry configuration not found, assuming the defaults")
+		return t
+	}
+
+	if prefix, ok := config["MetricsPrefix"].(string); ok && prefix != "" {
+		t.prefix = prefix
+	}
+
+	if disableHostname, ok := config["DisableHostname"].(bool); ok {
+		t.disableHostname = disableHostname
+	}
+
+	return t
+}
+
+// normalize returns the name a series would have with the default prefix and no hostname,
+// which is how the metric definitions name them. Only gauges carry the hostname.
+func (t *telemetry) normalize(name string, gauge bool, keys map[string]bool) string {
+	if t.denied {
+		return matchDefinition(name, keys)
+	}
+
+	rest := strings.TrimPrefix(name, t.prefix+".")
+	if rest == name {
+		return name
+	}
+
+	if gauge && !t.disableHostname && t.hostname != "" {
+		rest = strings.TrimPrefix(rest, t.hostname+".")
+	}
+
+	return defaultMetricsPrefix + "." + rest
+}
+
+// normalizeMetrics renames every series of the metrics as the metric definitions name them
+func (t *telemetry) normalizeMetrics(metricInfo *metricsInfo, keys map[string]bool) {
+	for idx := range metricInfo.Gauges {
+		metricInfo.Gauges[idx].Name = t.normalize(metricInfo.Gauges[idx].Name, true, keys)
+	}
+
+	for idx := range metricInfo.Counters {
+		metricInfo.Counters[idx].Name = t.normalize(metricInfo.Counters[idx].Name, false, keys)
+	}
+
+	for idx := range metricInfo.Samples {
+		metricInfo.Samples[idx].Name = t.normalize(metricInfo.Samples[idx].Name, false, keys)
+	}
+}
+
+// definitionKeys returns the API keys of every definition
+func definitionKeys(gaugeDefs, counterDefs []*metrics.MetricDefinition, timerDefs []*metrics.TimerDefinition) map[string]bool {
+	keys := make(map[string]bool)
+	for _, def := range gaugeDefs {
+		keys[def.APIKey] = true
+	}
+	for _, def := range counterDefs {
+		keys[def.APIKey] = true
+	}
+	for _, def := range timerDefs {
+		keys[def.APIKey] = true
+	}
+
+	return keys
+}
+
+// matchDefinition returns the definition API key a series name matches, for agents whose
+// configuration the token can't read. Series are named <metrics_prefix>.<name>, gauges also carry
+// the hostname after the prefix unless disable_hostname is set, so the leading segments are
+// dropped one at a time until the rest matches a definition with the default prefix.
+func matchDefinition(name string, keys map[string]bool) string {
+	if keys[name] {
+		return name
+	}
+
+	parts := strings.Split(name, ".")
+	for idx := 1; idx < len(parts); idx++ {
+		key := defaultMetricsPrefix + "." + strings.Join(parts[idx:], ".")
+		if keys[key] {
+			return key
+		}
+	}
+
+	return name
+}
+
+// warnMissingGauges warns about the gauge definitions the agent reported no series for,
+// once per definition and agent
+func (a *Agent) warnMissingGauges(missing []string) {
+	a.telemetryMu.Lock()
+	defer a.telemetryMu.Unlock()
+
+	var unwarned []string
+	for _, key := range missing {
+		if !a.warnedGauges[key] {
+			unwarned = append(unwarned, key)
+		}
+	}
+
+	if len(unwarned) == 0 {
+		return
+	}
+
+	if a.warnedGauges == nil {
+		a.warnedGauges = make(map[string]bool)
+	}
+	for _, key := range unwarned {
+		a.warnedGauges[key] = true
+	}
+
+	log.Warn("Agent %s reported no gauges matching %s, check its telemetry configuration", a.entity.Metadata.Name, strings.Join(unwarned, ", "))
+}
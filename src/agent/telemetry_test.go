@@ -0,0 +1,217 @@
+package agent
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/newrelic/infra-integrations-sdk/v3/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/v3/integration"
+	"github.com/newrelic/nri-consul/src/args"
+	"github.com/newrelic/nri-consul/src/metrics"
+	"github.com/newrelic/nri-consul/src/testutils"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_telemetry_normalize(t *testing.T) {
+	testCases := []struct {
+		name      string
+		telemetry telemetry
+		series    string
+		gauge     bool
+		want      string
+	}{
+		{"Default", telemetry{prefix: "consul", hostname: "consul-0"}, "consul.consul-0.runtime.num_goroutines", true, "consul.runtime.num_goroutines"},
+		{"No Hostname", telemetry{prefix: "consul", hostname: "consul-0", disableHostname: true}, "consul.runtime.num_goroutines", true, "consul.runtime.num_goroutines"},
+		{"Unknown Hostname", telemetry{prefix: "consul"}, "consul.runtime.num_goroutines", true, "consul.runtime.num_goroutines"},
+		{"Dotted Hostname", telemetry{prefix: "consul", hostname: "consul-0.example.com"}, "consul.consul-0.example.com.runtime.num_goroutines", true, "consul.runtime.num_goroutines"},
+		{"Custom Prefix", telemetry{prefix: "myorg.consul", hostname: "consul-0"}, "myorg.consul.consul-0.runtime.num_goroutines", true, "consul.runtime.num_goroutines"},
+		{"Counter", telemetry{prefix: "myorg", hostname: "client"}, "myorg.client.rpc", false, "consul.client.rpc"},
+		{"Other Prefix", telemetry{prefix: "myorg", hostname: "consul-0"}, "consul.runtime.num_goroutines", true, "consul.runtime.num_goroutines"},
+		{"Denied", telemetry{prefix: "consul", denied: true}, "myorg.consul-0.runtime.num_goroutines", true, "consul.runtime.num_goroutines"},
+	}
+
+	keys := map[string]bool{"consul.runtime.num_goroutines": true}
+	for _, tc := range testCases {
+		require.Equal(t, tc.want, tc.telemetry.normalize(tc.series, tc.gauge, keys), tc.name)
+	}
+}
+
+func Test_matchDefinition(t *testing.T) {
+	keys := map[string]bool{
+		"consul.runtime.num_goroutines": true,
+		"consul.client.rpc":             true,
+		"consul.client.rpc.failed":      true,
+		"consul.consul.state.services":  true,
+	}
+
+	testCases := []struct {
+		name   string
+		series string
+		want   string
+	}{
+		{"Default", "consul.consul-0.runtime.num_goroutines", "consul.runtime.num_goroutines"},
+		{"No Hostname", "consul.runtime.num_goroutines", "consul.runtime.num_goroutines"},
+		{"Dotted Hostname", "consul.consul-0.example.com.runtime.num_goroutines", "consul.runtime.num_goroutines"},
+		{"Custom Prefix", "myorg.consul.consul-0.runtime.num_goroutines", "consul.runtime.num_goroutines"},
+		{"Counter", "myorg.client.rpc", "consul.client.rpc"},
+		{"Longest Match", "myorg.client.rpc.failed", "consul.client.rpc.failed"},
+		{"Prefix In Name", "consul.consul-0.consul.state.services", "consul.consul.state.services"},
+		{"Unknown", "myorg.client.rpc.exceeded", "myorg.client.rpc.exceeded"},
+	}
+
+	for _, tc := range testCases {
+		require.Equal(t, tc.want, matchDefinition(tc.series, keys), tc.name)
+	}
+}
+
+func TestCollectCoreMetrics_Telemetry(t *testing.T) {
+	mux, hostname, port, serverClose := testutils.SetupServer()
+	defer serverClose()
+
+	arg := args.ArgumentList{
+		Hostname:  hostname,
+		Port:      port,
+		EnableSSL: false,
+		Timeout:   "0s",
+	}
+
+	apiConfig, err := arg.CreateAPIConfig(arg.Hostname)
+	require.NoError(t, err)
+
+	client, err := api.NewClient(apiConfig)
+	require.NoError(t, err)
+
+	i, err := integration.New("test", "1.0.0")
+	require.NoError(t, err)
+
+	entity, err := i.Entity("test", "agent")
+	require.NoError(t, err)
+
+	agent := NewAgent(client, entity, "consul-0", "10.0.0.1", "8301", "dc1", "")
+
+	selfRequests := 0
+	mux.HandleFunc("/v1/agent/self", func(w http.ResponseWriter, r *http.Request) {
+		selfRequests++
+		fmt.Fprint(w, `{
+			"Config": {"NodeName": "consul-0"},
+			"DebugConfig": {"Telemetry": {"MetricsPrefix": "myorg", "DisableHostname": false}}
+		}`)
+	})
+
+	mux.HandleFunc("/v1/agent/metrics", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{
+			"Gauges": [
+				{"Name": "myorg.consul-0.runtime.num_goroutines", "Value": 49, "Labels": {}}
+			],
+			"Counters": [
+				{"Name": "myorg.client.rpc", "Count": 5, "Rate": 0.5, "Sum": 5, "Labels": {}}
+			],
+			"Samples": []
+		}`)
+	})
+
+	gaugeDefs := []*metrics.MetricDefinition{
+		{APIKey: "consul.runtime.num_goroutines", MetricName: "runtime.goroutines", SourceType: metric.GAUGE},
+		{APIKey: "consul.runtime.heap_objects", MetricName: "runtime.heapObjects", SourceType: metric.GAUGE},
+	}
+	counterDefs := []*metrics.MetricDefinition{
+		{APIKey: "consul.client.rpc", MetricName: "client.rpcLoad", SourceType: metric.GAUGE},
+	}
+
+	metricSet := agent.newMetricSet()
+	for range []int{0, 1} {
+		require.NoError(t, agent.CollectCoreMetrics(metricSet, agent.newMetricSet, gaugeDefs, counterDefs, nil))
+	}
+
+	// the configuration is read once per agent, and missing gauges are warned about once
+	require.Equal(t, 1, selfRequests)
+	require.Equal(t, map[string]bool{"consul.runtime.heap_objects": true}, agent.warnedGauges)
+	require.Equal(t, float64(49), metricSet.Metrics["runtime.goroutines"])
+	require.Equal(t, float64(0.5), metricSet.Metrics["client.rpcLoad"])
+	require.NotContains(t, metricSet.Metrics, "runtime.heapObjects")
+}
+
+func TestCollectCoreMetrics_TelemetryDenied(t *testing.T) {
+	mux, hostname, port, serverClose := testutils.SetupServer()
+	defer serverClose()
+
+	arg := args.ArgumentList{
+		Hostname:  hostname,
+		Port:      port,
+		EnableSSL: false,
+		Timeout:   "0s",
+	}
+
+	apiConfig, err := arg.CreateAPIConfig(arg.Hostname)
+	require.NoError(t, err)
+
+	client, err := api.NewClient(apiConfig)
+	require.NoError(t, err)
+
+	i, err := integration.New("test", "1.0.0")
+	require.NoError(t, err)
+
+	entity, err := i.Entity("test", "agent")
+	require.NoError(t, err)
+
+	agent := NewAgent(client, entity, "consul-0", "10.0.0.1", "8301", "dc1", "")
+
+	selfRequests := 0
+	mux.HandleFunc("/v1/agent/self", func(w http.ResponseWriter, r *http.Request) {
+		selfRequests++
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "Permission denied: token lacks permission 'agent:read'")
+	})
+
+	mux.HandleFunc("/v1/agent/metrics", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{
+			"Gauges": [
+				{"Name": "myorg.consul-0.example.com.runtime.num_goroutines", "Value": 49, "Labels": {}}
+			],
+			"Counters": [
+				{"Name": "myorg.client.rpc", "Count": 5, "Rate": 0.5, "Sum": 5, "Labels": {}}
+			],
+			"Samples": []
+		}`)
+	})
+
+	gaugeDefs := []*metrics.MetricDefinition{
+		{APIKey: "consul.runtime.num_goroutines", MetricName: "runtime.goroutines", SourceType: metric.GAUGE},
+		{APIKey: "consul.runtime.heap_objects", MetricName: "runtime.heapObjects", SourceType: metric.GAUGE},
+	}
+	counterDefs := []*metrics.MetricDefinition{
+		{APIKey: "consul.client.rpc", MetricName: "client.rpcLoad", SourceType: metric.GAUGE},
+	}
+
+	metricSet := agent.newMetricSet()
+	for range []int{0, 1} {
+		require.NoError(t, agent.CollectCoreMetrics(metricSet, agent.newMetricSet, gaugeDefs, counterDefs, nil))
+	}
+
+	// without agent:read the series are matched against the definitions
+	require.Equal(t, 1, selfRequests)
+	require.Equal(t, float64(49), metricSet.Metrics["runtime.goroutines"])
+	require.Equal(t, float64(0.5), metricSet.Metrics["client.rpcLoad"])
+	require.NotContains(t, metricSet.Metrics, "runtime.heapObjects")
+}
+
+func Test_collectGaugeMetrics_Missing(t *testing.T) {
+	i, err := integration.New("test", "1.0.0")
+	require.NoError(t, err)
+
+	entity, err := i.Entity("test", "agent")
+	require.NoError(t, err)
+
+	gauges := []api.GaugeValue{
+		{Name: "consul.runtime.num_goroutines", Value: 49},
+	}
+	defs := []*metrics.MetricDefinition{
+		{APIKey: "consul.runtime.num_goroutines", MetricName: "runtime.goroutines", SourceType: metric.GAUGE},
+		{APIKey: "consul.runtime.heap_objects", MetricName: "runtime.heapObjects", SourceType: metric.GAUGE},
+	}
+
+	missing := collectGaugeMetrics(newLabeledSets(entity.NewMetricSet("ConsulAgentSample"), nil), gauges, defs)
+	require.Equal(t, []string{"consul.runtime.heap_objects"}, missing)
+}
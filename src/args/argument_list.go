@@ -2,29 +2,65 @@
 package args
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"text/template"
 	"time"
 
 	"github.com/hashicorp/consul/api"
 	goCleanhttp "github.com/hashicorp/go-cleanhttp"
 	sdkArgs "github.com/newrelic/infra-integrations-sdk/v3/args"
+	"github.com/newrelic/infra-integrations-sdk/v3/integration"
 )
 
 // ArgumentList struct that holds all Consul arguments
 type ArgumentList struct {
 	sdkArgs.DefaultArgumentList
-	Hostname               string `default:"localhost" help:"The agent node Hostname or IP address to connect to"`
-	Port                   string `default:"8500" help:"Port to connect to agent node"`
-	Token                  string `default:"" help:"ACL Token if token authentication is enabled"`
-	Timeout                string `default:"0s" help:"Timeout for an API call"`
-	EnableSSL              bool   `default:"false" help:"If true will use SSL encryption, false will not use encryption"`
-	TrustServerCertificate bool   `default:"false" help:"If true server certificate is not verified for SSL. If false certificate will be verified against supplied certificate"`
-	CABundleFile           string `default:"" help:"Alternative Certificate Authority bundle file"`
-	CABundleDir            string `default:"" help:"Alternative Certificate Authority bundle directory"`
-	FanOut                 bool   `default:"true" help:"If true will attempt to gather metrics from all other nodes in consul cluster"`
-	CheckLeadership        bool   `default:"true" help:"Check leadership on consul server. This should be disabled on consul in client mode"`
-	ShowVersion            bool   `default:"false" help:"Print build information and exit"`
+	Hostname               string `default:"localhost" help:"The agent node Hostname or IP address to connect to" yaml:"hostname"`
+	Port                   string `default:"8500" help:"Port to connect to agent node" yaml:"port"`
+	Token                  string `default:"" help:"ACL Token if token authentication is enabled" yaml:"token"`
+	TokenFile              string `default:"" help:"File containing the ACL Token, takes precedence over token. It's re-read when Consul no longer finds the token" yaml:"token_file"`
+	Timeout                string `default:"0s" help:"Timeout for an API call" yaml:"timeout"`
+	EnableSSL              bool   `default:"false" help:"If true will use SSL encryption, false will not use encryption" yaml:"enable_ssl"`
+	TrustServerCertificate bool   `default:"false" help:"If true server certificate is not verified for SSL. If false certificate will be verified against supplied certificate" yaml:"trust_server_certificate"`
+	CABundleFile           string `default:"" help:"Alternative Certificate Authority bundle file" yaml:"ca_bundle_file"`
+	CABundleDir            string `default:"" help:"Alternative Certificate Authority bundle directory" yaml:"ca_bundle_dir"`
+	ClientCertFile         string `default:"" help:"Client certificate file for mutual TLS, required if client_key_file is set" yaml:"client_cert_file"`
+	ClientKeyFile          string `default:"" help:"Client private key file for mutual TLS, required if client_cert_file is set" yaml:"client_key_file"`
+	TLSServerName          string `default:"" help:"Server name used to verify the agent certificates instead of the connection host" yaml:"tls_server_name"`
+	TLSServerNameTemplate  string `default:"" help:"Template of the server name used to verify each fan out agent certificate. e.g. server.{{.Datacenter}}.consul. Available fields are NodeName, Datacenter and Addr" yaml:"tls_server_name_template"`
+	TLSMinVersion          string `default:"" help:"Minimum TLS version accepted when SSL is enabled. One of 1.0, 1.1, 1.2 or 1.3" yaml:"tls_min_version"`
+	FanOut                 bool   `default:"true" help:"If true will attempt to gather metrics from all other nodes in consul cluster" yaml:"fan_out"`
+	CheckLeadership        bool   `default:"true" help:"Check leadership on consul server. This should be disabled on consul in client mode" yaml:"check_leadership"`
+	RemoteDatacenters      bool   `default:"false" help:"If true will also collect catalog, health and raft metrics for every WAN federated datacenter through the leader" yaml:"remote_datacenters"`
+	ListServiceInstances   bool   `default:"false" help:"If true the instances of every service are listed from the catalog to count the ones without service checks, one stale read per service" yaml:"list_service_instances"`
+	FanOutServersOnly      bool   `default:"false" help:"If true fan out collection only collects from server agents" yaml:"fan_out_servers_only"`
+	FanOutIncludeName      string `default:"" help:"Regular expression a member name must match to be collected by fan out" yaml:"fan_out_include_name"`
+	FanOutExcludeName      string `default:"" help:"Regular expression of member names that are not collected by fan out" yaml:"fan_out_exclude_name"`
+	FanOutIncludeTags      string `default:"" help:"Comma separated key=value member tags a member must all have to be collected by fan out. e.g. role=consul,segment=" yaml:"fan_out_include_tags"`
+	FanOutExcludeTags      string `default:"" help:"Comma separated key=value member tags, members with any of them are not collected by fan out" yaml:"fan_out_exclude_tags"`
+	FanOutIncludeNodeMeta  string `default:"" help:"Comma separated key=value node metadata a member node must all have to be collected by fan out" yaml:"fan_out_include_node_meta"`
+	ClusterName            string `default:"" help:"Name of the Consul cluster, used to tell apart the entities of clusters monitored together" yaml:"cluster_name"`
+	NodeIDEntityKey        bool   `default:"false" help:"If true agent entities are keyed by the Consul node ID instead of the member address and port, which change when agents are rescheduled" yaml:"node_id_entity_key"`
+	ConfigFile             string `default:"" help:"YAML file listing several Consul clusters to collect, each one overriding the rest of the arguments" yaml:"-"`
+	MetricDefinitionsFile  string `default:"" help:"YAML file of metric definitions collected along the built-in ones, shared by every cluster" yaml:"-"`
+	ShowVersion            bool   `default:"false" help:"Print build information and exit" yaml:"-"`
+}
+
+// DisableUnixSocketFanOut turns fan out collection off when the agent is reached through a unix socket,
+// e.g. seeded from CONSUL_HTTP_ADDR, as other agents can't be reached through it. It returns true if it did.
+func (al *ArgumentList) DisableUnixSocketFanOut() bool {
+	if !al.FanOut || !isUnixSocket(al.Hostname) {
+		return false
+	}
+
+	al.FanOut = false
+	return true
 }
 
 // Validate validates Consul arguments
@@ -33,28 +69,166 @@ func (al ArgumentList) Validate() error {
 		if !al.TrustServerCertificate && al.CABundleDir == "" && al.CABundleFile == "" {
 			return errors.New("invalid configuration: must specify a certificate file or bundle when using SSL and not trusting server certificate")
 		}
+
+		if (al.ClientCertFile == "") != (al.ClientKeyFile == "") {
+			return errors.New("invalid configuration: must specify both a client certificate file and a client key file when using mutual TLS")
+		}
+
+		if _, err := parseTLSVersion(al.TLSMinVersion); err != nil {
+			return fmt.Errorf("invalid configuration: %s", err.Error())
+		}
+
+		// executing against sample data also catches unknown fields
+		if _, err := al.memberServerName(&ServerNameData{}); err != nil {
+			return fmt.Errorf("invalid configuration: bad TLS server name template: %s", err.Error())
+		}
+	}
+
+	for _, expr := range []string{al.FanOutIncludeName, al.FanOutExcludeName} {
+		if _, err := regexp.Compile(expr); err != nil {
+			return fmt.Errorf("invalid configuration: bad member name regular expression '%s': %s", expr, err.Error())
+		}
+	}
+
+	for _, pairs := range []string{al.FanOutIncludeTags, al.FanOutExcludeTags, al.FanOutIncludeNodeMeta} {
+		if _, err := ParseKeyValues(pairs); err != nil {
+			return fmt.Errorf("invalid configuration: %s", err.Error())
+		}
 	}
 
 	return nil
 }
 
+// ParseKeyValues parses a comma separated list of key=value pairs.
+// Values may be empty but every pair must have a key.
+func ParseKeyValues(pairs string) (map[string]string, error) {
+	result := make(map[string]string)
+	if strings.TrimSpace(pairs) == "" {
+		return result, nil
+	}
+
+	for _, pair := range strings.Split(pairs, ",") {
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("'%s' is not a key=value pair", pair)
+		}
+
+		result[key] = strings.TrimSpace(value)
+	}
+
+	return result, nil
+}
+
+// ClusterIDAttributes returns the identity attributes that tell apart the entities of a cluster
+// from the ones of other clusters, none if the cluster has no name
+func ClusterIDAttributes(clusterName string) []integration.IDAttribute {
+	if clusterName == "" {
+		return nil
+	}
+
+	return []integration.IDAttribute{integration.NewIDAttribute("clusterName", clusterName)}
+}
+
+// tlsVersions maps the accepted TLS version arguments to their crypto/tls value
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseTLSVersion returns the crypto/tls value of a TLS version, 0 if no version is given
+func parseTLSVersion(version string) (uint16, error) {
+	if version == "" {
+		return 0, nil
+	}
+
+	value, ok := tlsVersions[version]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version '%s'", version)
+	}
+
+	return value, nil
+}
+
+// ServerNameData holds the member fields available to the TLS server name template
+type ServerNameData struct {
+	NodeName   string
+	Datacenter string
+	Addr       string
+}
+
+// memberServerName renders the TLS server name template for a member, falling back to
+// the static TLS server name when there is no template
+func (al ArgumentList) memberServerName(data *ServerNameData) (string, error) {
+	if al.TLSServerNameTemplate == "" {
+		return al.TLSServerName, nil
+	}
+
+	tmpl, err := template.New("serverName").Parse(al.TLSServerNameTemplate)
+	if err != nil {
+		return "", err
+	}
+
+	var serverName strings.Builder
+	if err := tmpl.Execute(&serverName, data); err != nil {
+		return "", err
+	}
+
+	return serverName.String(), nil
+}
+
+// CreateMemberAPIConfig creates an API config for a fan out member, connecting to its address
+// and verifying its certificate against the server name rendered for it
+func (al ArgumentList) CreateMemberAPIConfig(member *api.AgentMember) (*api.Config, error) {
+	if al.EnableSSL {
+		serverName, err := al.memberServerName(&ServerNameData{
+			NodeName:   member.Name,
+			Datacenter: member.Tags[api.MemberTagKeyDatacenter],
+			Addr:       member.Addr,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		al.TLSServerName = serverName
+	}
+
+	return al.CreateAPIConfig(member.Addr)
+}
+
 // CreateAPIConfig creates an API config from the argument list
 func (al ArgumentList) CreateAPIConfig(hostname string) (*api.Config, error) {
 	// Since we are creating the HttpClient instead of using the default (so we can define a Timeout)
 	// we must set the Transport with the same defaults used in consul's api.NewClient.
 	config := &api.Config{
-		Address: fmt.Sprintf("%s:%s", hostname, al.Port),
+		Address: net.JoinHostPort(strings.Trim(hostname, "[]"), al.Port),
 		Token:   al.Token,
 		Scheme:  "http",
 		// Using the same as the consul api.NewClient
 		Transport: goCleanhttp.DefaultPooledTransport(),
 	}
 
+	// the consul api replaces the HttpClient for unix URLs, dropping the Timeout,
+	// so the socket is dialed by our own transport instead
+	if isUnixSocket(hostname) {
+		socketPath := strings.TrimPrefix(hostname, unixScheme)
+		config.Address = "localhost"
+		config.Transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socketPath)
+		}
+	}
+
 	// setup SSL if enabled
 	if al.EnableSSL {
 		config.TLSConfig = api.TLSConfig{
 			CAFile:             al.CABundleFile,
 			CAPath:             al.CABundleDir,
+			CertFile:           al.ClientCertFile,
+			KeyFile:            al.ClientKeyFile,
+			Address:            al.TLSServerName,
 			InsecureSkipVerify: al.TrustServerCertificate,
 		}
 		config.Scheme = "https"
@@ -65,12 +239,34 @@ func (al ArgumentList) CreateAPIConfig(hostname string) (*api.Config, error) {
 		return nil, err
 	}
 
+	// the consul api has no minimum version option, it's set on the TLS config it built for the transport
+	if al.EnableSSL {
+		minVersion, err := parseTLSVersion(al.TLSMinVersion)
+		if err != nil {
+			return nil, err
+		}
+
+		if minVersion != 0 {
+			config.Transport.TLSClientConfig.MinVersion = minVersion
+		}
+	}
+
 	duration, err := time.ParseDuration(al.Timeout)
 	if err != nil {
 		return nil, err
 	}
 
 	httpClient.Timeout = duration
+
+	if al.TokenFile != "" {
+		transport, err := newTokenFileTransport(httpClient.Transport, al.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+
+		httpClient.Transport = transport
+		config.Token = ""
+	}
 	config.HttpClient = httpClient
 
 	return config, nil
@@ -1,10 +1,14 @@
 package args
 
 import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
 	"path/filepath"
 	"testing"
 
 	"github.com/hashicorp/consul/api"
+	"github.com/newrelic/nri-consul/src/testutils"
 	"github.com/stretchr/testify/require"
 )
 
@@ -44,6 +48,121 @@ func Test_ArgumentList_Validate(t *testing.T) {
 			},
 			false,
 		},
+		{
+			"Mutual TLS Ok",
+			&ArgumentList{
+				Hostname:       "localhost",
+				Port:           "8500",
+				EnableSSL:      true,
+				CABundleFile:   "my.pem",
+				ClientCertFile: "client.pem",
+				ClientKeyFile:  "client-key.pem",
+				TLSMinVersion:  "1.2",
+			},
+			false,
+		},
+		{
+			"Client Cert Without Key",
+			&ArgumentList{
+				Hostname:       "localhost",
+				Port:           "8500",
+				EnableSSL:      true,
+				CABundleFile:   "my.pem",
+				ClientCertFile: "client.pem",
+			},
+			true,
+		},
+		{
+			"Client Key Without Cert",
+			&ArgumentList{
+				Hostname:      "localhost",
+				Port:          "8500",
+				EnableSSL:     true,
+				CABundleFile:  "my.pem",
+				ClientKeyFile: "client-key.pem",
+			},
+			true,
+		},
+		{
+			"Unknown TLS Version",
+			&ArgumentList{
+				Hostname:      "localhost",
+				Port:          "8500",
+				EnableSSL:     true,
+				CABundleFile:  "my.pem",
+				TLSMinVersion: "1.4",
+			},
+			true,
+		},
+		{
+			"TLS Server Name Template Ok",
+			&ArgumentList{
+				Hostname:              "localhost",
+				Port:                  "8500",
+				EnableSSL:             true,
+				CABundleFile:          "my.pem",
+				TLSServerNameTemplate: "{{.NodeName}}.server.{{.Datacenter}}.consul",
+			},
+			false,
+		},
+		{
+			"Bad TLS Server Name Template",
+			&ArgumentList{
+				Hostname:              "localhost",
+				Port:                  "8500",
+				EnableSSL:             true,
+				CABundleFile:          "my.pem",
+				TLSServerNameTemplate: "server.{{.Datacenter}.consul",
+			},
+			true,
+		},
+		{
+			"Unknown TLS Server Name Template Field",
+			&ArgumentList{
+				Hostname:              "localhost",
+				Port:                  "8500",
+				EnableSSL:             true,
+				CABundleFile:          "my.pem",
+				TLSServerNameTemplate: "server.{{.Region}}.consul",
+			},
+			true,
+		},
+		{
+			"Unix Socket Ok",
+			&ArgumentList{
+				Hostname: "unix:///var/run/consul.sock",
+			},
+			false,
+		},
+		{
+			"Fan Out Filters Ok",
+			&ArgumentList{
+				Hostname:              "localhost",
+				Port:                  "8500",
+				FanOutIncludeName:     "^consul-",
+				FanOutIncludeTags:     "role=consul,segment=",
+				FanOutIncludeNodeMeta: "kind=gateway",
+			},
+			false,
+		},
+		{
+			"Bad Fan Out Name Regex",
+			&ArgumentList{
+				Hostname:          "localhost",
+				Port:              "8500",
+				FanOutExcludeName: "[",
+			},
+			true,
+		},
+		{
+			"Bad Fan Out Tags",
+			&ArgumentList{
+				Hostname:          "localhost",
+				Port:              "8500",
+				FanOutExcludeTags: "role",
+			},
+			true,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -56,6 +175,30 @@ func Test_ArgumentList_Validate(t *testing.T) {
 	}
 }
 
+func Test_ParseKeyValues(t *testing.T) {
+	testCases := []struct {
+		name      string
+		input     string
+		want      map[string]string
+		wantError bool
+	}{
+		{"Empty", "", map[string]string{}, false},
+		{"Pairs", "role=consul, segment=", map[string]string{"role": "consul", "segment": ""}, false},
+		{"Missing Separator", "role", nil, true},
+		{"Missing Key", "=consul", nil, true},
+	}
+
+	for _, tc := range testCases {
+		out, err := ParseKeyValues(tc.input)
+		if tc.wantError {
+			require.Error(t, err, tc.name)
+			continue
+		}
+		require.NoError(t, err, tc.name)
+		require.Equal(t, tc.want, out, tc.name)
+	}
+}
+
 func Test_ArgumentList_CreateAPIConfig(t *testing.T) {
 	testCases := []struct {
 		name      string
@@ -103,6 +246,83 @@ func Test_ArgumentList_CreateAPIConfig(t *testing.T) {
 			},
 			false,
 		},
+		{
+			"Mutual TLS",
+			&ArgumentList{
+				Hostname:       "localhost",
+				Port:           "8500",
+				EnableSSL:      true,
+				CABundleFile:   filepath.Join("testdata", "ca.pem"),
+				ClientCertFile: filepath.Join("testdata", "client.pem"),
+				ClientKeyFile:  filepath.Join("testdata", "client-key.pem"),
+				TLSServerName:  "consul.service.consul",
+				Timeout:        "30s",
+			},
+			&api.Config{
+				Address: "localhost:8500",
+				Scheme:  "https",
+				TLSConfig: api.TLSConfig{
+					CAFile:   filepath.Join("testdata", "ca.pem"),
+					CertFile: filepath.Join("testdata", "client.pem"),
+					KeyFile:  filepath.Join("testdata", "client-key.pem"),
+					Address:  "consul.service.consul",
+				},
+			},
+			false,
+		},
+		{
+			"Missing Client Key File",
+			&ArgumentList{
+				Hostname:               "localhost",
+				Port:                   "8500",
+				EnableSSL:              true,
+				TrustServerCertificate: true,
+				ClientCertFile:         filepath.Join("testdata", "client.pem"),
+				ClientKeyFile:          filepath.Join("testdata", "missing.pem"),
+				Timeout:                "30s",
+			},
+			nil,
+			true,
+		},
+		{
+			"Unknown TLS Version",
+			&ArgumentList{
+				Hostname:               "localhost",
+				Port:                   "8500",
+				EnableSSL:              true,
+				TrustServerCertificate: true,
+				TLSMinVersion:          "1.4",
+				Timeout:                "30s",
+			},
+			nil,
+			true,
+		},
+		{
+			"IPv6 Hostname",
+			&ArgumentList{
+				Hostname: "fd00::1",
+				Port:     "8500",
+				Timeout:  "30s",
+			},
+			&api.Config{
+				Address: "[fd00::1]:8500",
+				Scheme:  "http",
+			},
+			false,
+		},
+		{
+			"Bracketed IPv6 Hostname",
+			&ArgumentList{
+				Hostname: "[fd00::1]",
+				Port:     "8500",
+				Timeout:  "30s",
+			},
+			&api.Config{
+				Address: "[fd00::1]:8500",
+				Scheme:  "http",
+			},
+			false,
+		},
 		{
 			"Wrong timeout format",
 			&ArgumentList{
@@ -125,3 +345,177 @@ func Test_ArgumentList_CreateAPIConfig(t *testing.T) {
 		require.Equal(t, tc.want.TLSConfig, out.TLSConfig)
 	}
 }
+
+func Test_ArgumentList_CreateMemberAPIConfig(t *testing.T) {
+	member := &api.AgentMember{
+		Name: "consul-1",
+		Addr: "10.0.0.2",
+		Tags: map[string]string{
+			"dc": "dc1",
+		},
+	}
+
+	testCases := []struct {
+		name           string
+		args           *ArgumentList
+		wantServerName string
+		wantError      bool
+	}{
+		{
+			"Template",
+			&ArgumentList{
+				EnableSSL:             true,
+				TLSServerName:         "consul.service.consul",
+				TLSServerNameTemplate: "server.{{.Datacenter}}.consul",
+			},
+			"server.dc1.consul",
+			false,
+		},
+		{
+			"Node Name Template",
+			&ArgumentList{
+				EnableSSL:             true,
+				TLSServerNameTemplate: "{{.NodeName}}.node.{{.Datacenter}}.consul",
+			},
+			"consul-1.node.dc1.consul",
+			false,
+		},
+		{
+			"Static Server Name",
+			&ArgumentList{
+				EnableSSL:     true,
+				TLSServerName: "consul.service.consul",
+			},
+			"consul.service.consul",
+			false,
+		},
+		{
+			"SSL Disabled",
+			&ArgumentList{
+				TLSServerNameTemplate: "server.{{.Datacenter}}.consul",
+			},
+			"",
+			false,
+		},
+		{
+			"Bad Template",
+			&ArgumentList{
+				EnableSSL:             true,
+				TLSServerNameTemplate: "server.{{.Region}}.consul",
+			},
+			"",
+			true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc.args.Port = "8500"
+		tc.args.TrustServerCertificate = true
+		tc.args.Timeout = "30s"
+
+		out, err := tc.args.CreateMemberAPIConfig(member)
+		if tc.wantError {
+			require.Error(t, err, tc.name)
+			continue
+		}
+		require.NoError(t, err, tc.name)
+		require.Equal(t, "10.0.0.2:8500", out.Address, tc.name)
+		require.Equal(t, tc.wantServerName, out.TLSConfig.Address, tc.name)
+	}
+}
+
+func Test_ArgumentList_CreateAPIConfig_MutualTLS(t *testing.T) {
+	mux, hostname, port, serverClose := testutils.SetupTLSServer(&tls.Config{
+		ClientAuth: tls.RequireAnyClientCert,
+		MaxVersion: tls.VersionTLS12,
+	})
+	defer serverClose()
+
+	mux.HandleFunc("/v1/status/leader", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `"10.0.0.1:8300"`)
+	})
+
+	testCases := []struct {
+		name      string
+		args      *ArgumentList
+		wantError bool
+	}{
+		{
+			"Client Certificate",
+			&ArgumentList{
+				ClientCertFile: filepath.Join("testdata", "client.pem"),
+				ClientKeyFile:  filepath.Join("testdata", "client-key.pem"),
+			},
+			false,
+		},
+		{
+			"No Client Certificate",
+			&ArgumentList{},
+			true,
+		},
+		{
+			"Minimum Version Above Server",
+			&ArgumentList{
+				ClientCertFile: filepath.Join("testdata", "client.pem"),
+				ClientKeyFile:  filepath.Join("testdata", "client-key.pem"),
+				TLSMinVersion:  "1.3",
+			},
+			true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc.args.Hostname = hostname
+		tc.args.Port = port
+		tc.args.EnableSSL = true
+		tc.args.TrustServerCertificate = true
+		tc.args.Timeout = "5s"
+
+		apiConfig, err := tc.args.CreateAPIConfig(tc.args.Hostname)
+		require.NoError(t, err, tc.name)
+
+		client, err := api.NewClient(apiConfig)
+		require.NoError(t, err, tc.name)
+
+		leader, err := client.Status().Leader()
+		if tc.wantError {
+			require.Error(t, err, tc.name)
+			continue
+		}
+		require.NoError(t, err, tc.name)
+		require.Equal(t, "10.0.0.1:8300", leader, tc.name)
+	}
+}
+
+func Test_ArgumentList_CreateAPIConfig_UnixSocket(t *testing.T) {
+	al := ArgumentList{
+		Hostname: "unix:///var/run/consul.sock",
+		Port:     "8500",
+		Timeout:  "30s",
+	}
+
+	out, err := al.CreateAPIConfig(al.Hostname)
+	require.NoError(t, err)
+	require.Equal(t, "localhost", out.Address)
+	require.NotNil(t, out.Transport.DialContext)
+}
+
+func Test_ArgumentList_DisableUnixSocketFanOut(t *testing.T) {
+	testCases := []struct {
+		name        string
+		args        ArgumentList
+		wantFanOut  bool
+		wantChanged bool
+	}{
+		{"Unix Socket Fan Out", ArgumentList{Hostname: "unix:///var/run/consul.sock", FanOut: true}, false, true},
+		{"Unix Socket Local", ArgumentList{Hostname: "unix:///var/run/consul.sock"}, false, false},
+		{"TCP Fan Out", ArgumentList{Hostname: "localhost", Port: "8500", FanOut: true}, true, false},
+	}
+
+	for _, tc := range testCases {
+		require.Equal(t, tc.wantChanged, tc.args.DisableUnixSocketFanOut(), tc.name)
+		require.Equal(t, tc.wantFanOut, tc.args.FanOut, tc.name)
+		// the fallback configuration is valid
+		require.NoError(t, tc.args.Validate(), tc.name)
+	}
+}
@@ -0,0 +1,116 @@
+package args
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFile is the layout of the config file, each cluster is a mapping of
+// cluster_name plus the arguments overridden for it, using their snake case name
+type configFile struct {
+	Clusters []yaml.Node `yaml:"clusters"`
+}
+
+// Clusters returns the arguments of every cluster to collect. Without a config file it's just
+// the one described by the arguments, otherwise every cluster of the file with its arguments
+// applied on top of these ones.
+func (al ArgumentList) Clusters() ([]ArgumentList, error) {
+	if al.ConfigFile == "" {
+		return []ArgumentList{al}, nil
+	}
+
+	data, err := os.ReadFile(al.ConfigFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %s", err.Error())
+	}
+
+	var config configFile
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("error parsing config file: %s", err.Error())
+	}
+
+	if len(config.Clusters) == 0 {
+		return nil, errors.New("config file doesn't list any clusters")
+	}
+
+	clusters := make([]ArgumentList, 0, len(config.Clusters))
+	names := make(map[string]bool)
+	for idx := range config.Clusters {
+		cluster, err := al.decodeCluster(&config.Clusters[idx])
+		if err != nil {
+			return nil, fmt.Errorf("bad cluster %d in config file: %s", idx, err.Error())
+		}
+
+		if names[cluster.ClusterName] {
+			return nil, fmt.Errorf("duplicated cluster_name '%s' in config file", cluster.ClusterName)
+		}
+		names[cluster.ClusterName] = true
+
+		clusters = append(clusters, *cluster)
+	}
+
+	return clusters, nil
+}
+
+// decodeCluster applies a cluster mapping of the config file over a copy of the arguments
+func (al ArgumentList) decodeCluster(node *yaml.Node) (*ArgumentList, error) {
+	if node.Kind != yaml.MappingNode {
+		return nil, errors.New("must be a mapping")
+	}
+
+	known := configFileKeys()
+	keys := make(map[string]bool)
+	for idx := 0; idx < len(node.Content); idx += 2 {
+		key := node.Content[idx].Value
+		if !known[key] {
+			return nil, fmt.Errorf("unknown argument '%s'", key)
+		}
+		keys[key] = true
+	}
+
+	cluster := al
+	// the config file can't point to another one
+	cluster.ConfigFile = ""
+
+	if err := node.Decode(&cluster); err != nil {
+		return nil, err
+	}
+
+	// every cluster needs its own name so their entities don't collide
+	if cluster.ClusterName == "" {
+		return nil, errors.New("cluster_name is required")
+	}
+
+	if keys["hostname"] {
+		if err := cluster.applyAddress(cluster.Hostname, keys["port"]); err != nil {
+			return nil, err
+		}
+	}
+
+	return &cluster, nil
+}
+
+// configFileKeys returns the names of the arguments that can be set per cluster
+func configFileKeys() map[string]bool {
+	keys := make(map[string]bool)
+
+	argsType := reflect.TypeOf(ArgumentList{})
+	for i := 0; i < argsType.NumField(); i++ {
+		field := argsType.Field(i)
+		if field.Anonymous {
+			continue
+		}
+
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name != "" && name != "-" {
+			keys[name] = true
+		}
+	}
+
+	return keys
+}
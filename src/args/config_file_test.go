@@ -0,0 +1,108 @@
+package args
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/integration"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "consul-clusters.yml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func Test_ArgumentList_Clusters(t *testing.T) {
+	base := ArgumentList{
+		Hostname:     "localhost",
+		Port:         "8500",
+		Timeout:      "30s",
+		EnableSSL:    true,
+		CABundleFile: "ca.pem",
+		FanOut:       true,
+		ConfigFile: writeConfigFile(t, `
+clusters:
+  - cluster_name: east
+    hostname: consul-east.example.com
+    token_file: /run/secrets/east
+  - cluster_name: west
+    hostname: https://consul-west.example.com:8501
+    ca_bundle_file: west-ca.pem
+    fan_out: false
+`),
+	}
+
+	out, err := base.Clusters()
+	require.NoError(t, err)
+
+	east := base
+	east.ConfigFile = ""
+	east.ClusterName = "east"
+	east.Hostname = "consul-east.example.com"
+	east.TokenFile = "/run/secrets/east"
+
+	west := base
+	west.ConfigFile = ""
+	west.ClusterName = "west"
+	west.Hostname = "consul-west.example.com"
+	west.Port = "8501"
+	west.CABundleFile = "west-ca.pem"
+	west.FanOut = false
+
+	require.Equal(t, []ArgumentList{east, west}, out)
+}
+
+func Test_ArgumentList_Clusters_NoConfigFile(t *testing.T) {
+	base := ArgumentList{
+		Hostname: "localhost",
+		Port:     "8500",
+	}
+
+	out, err := base.Clusters()
+	require.NoError(t, err)
+	require.Equal(t, []ArgumentList{base}, out)
+}
+
+func Test_ArgumentList_Clusters_Errors(t *testing.T) {
+	testCases := []struct {
+		name    string
+		content string
+	}{
+		{"No Clusters", "clusters: []"},
+		{"Not A Mapping", "clusters:\n  - east"},
+		{"Missing Cluster Name", "clusters:\n  - hostname: consul-east"},
+		{"Duplicated Cluster Name", "clusters:\n  - cluster_name: east\n  - cluster_name: east"},
+		{"Unknown Argument", "clusters:\n  - cluster_name: east\n    hostnme: consul-east"},
+		{"Nested Config File", "clusters:\n  - cluster_name: east\n    config_file: other.yml"},
+		{"Bad Value", "clusters:\n  - cluster_name: east\n    fan_out: maybe"},
+		{"Bad Address", "clusters:\n  - cluster_name: east\n    hostname: ftp://consul-east"},
+		{"Bad YAML", "clusters: ["},
+	}
+
+	for _, tc := range testCases {
+		base := ArgumentList{
+			ConfigFile: writeConfigFile(t, tc.content),
+		}
+
+		_, err := base.Clusters()
+		require.Error(t, err, tc.name)
+	}
+
+	base := ArgumentList{
+		ConfigFile: filepath.Join(t.TempDir(), "missing.yml"),
+	}
+
+	_, err := base.Clusters()
+	require.Error(t, err)
+}
+
+func Test_ClusterIDAttributes(t *testing.T) {
+	require.Empty(t, ClusterIDAttributes(""))
+	require.Equal(t,
+		[]integration.IDAttribute{integration.NewIDAttribute("clusterName", "east")},
+		ClusterIDAttributes("east"),
+	)
+}
@@ -0,0 +1,131 @@
+package args
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// unixScheme prefixes the Hostname of agents listening on a unix socket
+const unixScheme = "unix://"
+
+// ApplyConsulEnv seeds the arguments from the standard Consul CLI environment variables
+// and splits a URL Hostname into its parts. Arguments set explicitly, through the
+// command line or their own environment variable, always win.
+func (al *ArgumentList) ApplyConsulEnv() error {
+	return al.applyConsulEnv(os.Getenv, explicitArgs())
+}
+
+// explicitArgs returns the names of the arguments that were not left to their default value
+func explicitArgs() map[string]bool {
+	explicit := make(map[string]bool)
+
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	// the SDK sets the arguments from the environment without marking the flags as set
+	flag.VisitAll(func(f *flag.Flag) {
+		if os.Getenv(strings.ToUpper(f.Name)) != "" {
+			explicit[f.Name] = true
+		}
+	})
+
+	return explicit
+}
+
+func (al *ArgumentList) applyConsulEnv(getenv func(string) string, explicit map[string]bool) error {
+	setString := func(arg, env string, target *string) {
+		if value := getenv(env); value != "" && !explicit[arg] {
+			*target = value
+		}
+	}
+
+	if value := getenv("CONSUL_HTTP_SSL"); value != "" && !explicit["enable_ssl"] {
+		enableSSL, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("bad CONSUL_HTTP_SSL value '%s': %s", value, err.Error())
+		}
+		al.EnableSSL = enableSSL
+	}
+
+	if value := getenv("CONSUL_HTTP_SSL_VERIFY"); value != "" && !explicit["trust_server_certificate"] {
+		verify, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("bad CONSUL_HTTP_SSL_VERIFY value '%s': %s", value, err.Error())
+		}
+		al.TrustServerCertificate = !verify
+	}
+
+	setString("token", "CONSUL_HTTP_TOKEN", &al.Token)
+	setString("ca_bundle_file", "CONSUL_CACERT", &al.CABundleFile)
+	setString("ca_bundle_dir", "CONSUL_CAPATH", &al.CABundleDir)
+	setString("client_cert_file", "CONSUL_CLIENT_CERT", &al.ClientCertFile)
+	setString("client_key_file", "CONSUL_CLIENT_KEY", &al.ClientKeyFile)
+	setString("tls_server_name", "CONSUL_TLS_SERVER_NAME", &al.TLSServerName)
+
+	// as in the Consul CLI an explicit token takes precedence over the token file environment variable
+	if !explicit["token"] {
+		setString("token_file", "CONSUL_HTTP_TOKEN_FILE", &al.TokenFile)
+	}
+
+	addr := al.Hostname
+	if !explicit["hostname"] && getenv("CONSUL_HTTP_ADDR") != "" {
+		addr = getenv("CONSUL_HTTP_ADDR")
+	}
+
+	return al.applyAddress(addr, explicit["port"])
+}
+
+// applyAddress sets the Hostname, Port and SSL arguments from an address, that can be a plain host,
+// a host:port pair or a http, https or unix URL. An explicit Port is kept.
+func (al *ArgumentList) applyAddress(addr string, explicitPort bool) error {
+	scheme, rest, hasScheme := strings.Cut(addr, "://")
+	if !hasScheme {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			// not a host:port pair, the address is the host
+			al.Hostname = addr
+			return nil
+		}
+
+		al.Hostname = host
+		if !explicitPort {
+			al.Port = port
+		}
+		return nil
+	}
+
+	switch scheme {
+	case "unix":
+		al.Hostname = unixScheme + rest
+		return nil
+	case "http", "https":
+		u, err := url.Parse(addr)
+		if err != nil {
+			return fmt.Errorf("bad address '%s': %s", addr, err.Error())
+		}
+
+		al.Hostname = u.Hostname()
+		if u.Port() != "" && !explicitPort {
+			al.Port = u.Port()
+		}
+
+		// never revert to http if SSL was requested
+		if scheme == "https" {
+			al.EnableSSL = true
+		}
+		return nil
+	default:
+		return fmt.Errorf("bad address '%s': unknown scheme '%s'", addr, scheme)
+	}
+}
+
+// isUnixSocket returns true if the hostname is the URL of a unix socket
+func isUnixSocket(hostname string) bool {
+	return strings.HasPrefix(hostname, unixScheme)
+}
@@ -0,0 +1,156 @@
+package args
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ArgumentList_applyConsulEnv(t *testing.T) {
+	testCases := []struct {
+		name      string
+		env       map[string]string
+		explicit  map[string]bool
+		args      ArgumentList
+		want      ArgumentList
+		wantError bool
+	}{
+		{
+			"No Environment",
+			nil,
+			nil,
+			ArgumentList{Hostname: "localhost", Port: "8500"},
+			ArgumentList{Hostname: "localhost", Port: "8500"},
+			false,
+		},
+		{
+			"Consul Environment",
+			map[string]string{
+				"CONSUL_HTTP_ADDR":       "https://consul.service:8501",
+				"CONSUL_HTTP_TOKEN":      "env_token",
+				"CONSUL_CACERT":          "ca.pem",
+				"CONSUL_CAPATH":          "certs",
+				"CONSUL_CLIENT_CERT":     "client.pem",
+				"CONSUL_CLIENT_KEY":      "client-key.pem",
+				"CONSUL_TLS_SERVER_NAME": "server.dc1.consul",
+				"CONSUL_HTTP_SSL_VERIFY": "false",
+			},
+			nil,
+			ArgumentList{Hostname: "localhost", Port: "8500"},
+			ArgumentList{
+				Hostname:               "consul.service",
+				Port:                   "8501",
+				Token:                  "env_token",
+				EnableSSL:              true,
+				TrustServerCertificate: true,
+				CABundleFile:           "ca.pem",
+				CABundleDir:            "certs",
+				ClientCertFile:         "client.pem",
+				ClientKeyFile:          "client-key.pem",
+				TLSServerName:          "server.dc1.consul",
+			},
+			false,
+		},
+		{
+			"Explicit Arguments Win",
+			map[string]string{
+				"CONSUL_HTTP_ADDR":  "consul.service:8501",
+				"CONSUL_HTTP_TOKEN": "env_token",
+				"CONSUL_HTTP_SSL":   "true",
+			},
+			map[string]bool{"hostname": true, "token": true, "enable_ssl": true},
+			ArgumentList{Hostname: "10.0.0.1", Port: "8500", Token: "my_token"},
+			ArgumentList{Hostname: "10.0.0.1", Port: "8500", Token: "my_token"},
+			false,
+		},
+		{
+			"Explicit Port Wins",
+			map[string]string{"CONSUL_HTTP_ADDR": "consul.service:8501"},
+			map[string]bool{"port": true},
+			ArgumentList{Hostname: "localhost", Port: "8500"},
+			ArgumentList{Hostname: "consul.service", Port: "8500"},
+			false,
+		},
+		{
+			"Token File",
+			map[string]string{
+				"CONSUL_HTTP_TOKEN":      "env_token",
+				"CONSUL_HTTP_TOKEN_FILE": "/run/consul/token",
+			},
+			nil,
+			ArgumentList{Hostname: "localhost", Port: "8500"},
+			ArgumentList{Hostname: "localhost", Port: "8500", Token: "env_token", TokenFile: "/run/consul/token"},
+			false,
+		},
+		{
+			"Explicit Token Wins Over Token File",
+			map[string]string{"CONSUL_HTTP_TOKEN_FILE": "/run/consul/token"},
+			map[string]bool{"token": true},
+			ArgumentList{Hostname: "localhost", Port: "8500", Token: "my_token"},
+			ArgumentList{Hostname: "localhost", Port: "8500", Token: "my_token"},
+			false,
+		},
+		{
+			"Bad SSL Value",
+			map[string]string{"CONSUL_HTTP_SSL": "maybe"},
+			nil,
+			ArgumentList{Hostname: "localhost", Port: "8500"},
+			ArgumentList{},
+			true,
+		},
+		{
+			"HTTPS Hostname URL",
+			nil,
+			map[string]bool{"hostname": true},
+			ArgumentList{Hostname: "https://consul.service:8501", Port: "8500"},
+			ArgumentList{Hostname: "consul.service", Port: "8501", EnableSSL: true},
+			false,
+		},
+		{
+			"HTTP Hostname URL Without Port",
+			nil,
+			map[string]bool{"hostname": true},
+			ArgumentList{Hostname: "http://consul.service", Port: "8500"},
+			ArgumentList{Hostname: "consul.service", Port: "8500"},
+			false,
+		},
+		{
+			"IPv6 Hostname URL",
+			nil,
+			map[string]bool{"hostname": true},
+			ArgumentList{Hostname: "http://[fd00::1]:8501", Port: "8500"},
+			ArgumentList{Hostname: "fd00::1", Port: "8501"},
+			false,
+		},
+		{
+			"Unix Socket Hostname URL",
+			nil,
+			map[string]bool{"hostname": true},
+			ArgumentList{Hostname: "unix:///var/run/consul.sock", Port: "8500"},
+			ArgumentList{Hostname: "unix:///var/run/consul.sock", Port: "8500"},
+			false,
+		},
+		{
+			"Unknown Scheme",
+			nil,
+			map[string]bool{"hostname": true},
+			ArgumentList{Hostname: "ftp://consul.service", Port: "8500"},
+			ArgumentList{},
+			true,
+		},
+	}
+
+	for _, tc := range testCases {
+		getenv := func(key string) string {
+			return tc.env[key]
+		}
+
+		err := tc.args.applyConsulEnv(getenv, tc.explicit)
+		if tc.wantError {
+			require.Error(t, err, tc.name)
+			continue
+		}
+		require.NoError(t, err, tc.name)
+		require.Equal(t, tc.want, tc.args, tc.name)
+	}
+}
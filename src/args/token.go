@@ -0,0 +1,155 @@
+package args
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/log"
+)
+
+// aclNotFound is the error returned by Consul for tokens it doesn't know, like a rotated one
+const aclNotFound = "ACL not found"
+
+// tokenSource holds the ACL token read from a file. It's shared by every client using the file,
+// fan out creates one per member, so the file is read once and re-read once per rotation.
+type tokenSource struct {
+	path  string
+	mu    sync.Mutex
+	token string
+}
+
+var (
+	tokenSourcesMu sync.Mutex
+	tokenSources   = make(map[string]*tokenSource)
+)
+
+// getTokenSource returns the tokenSource of a token file, reading the file the first time
+func getTokenSource(path string) (*tokenSource, error) {
+	tokenSourcesMu.Lock()
+	defer tokenSourcesMu.Unlock()
+
+	if ts, ok := tokenSources[path]; ok {
+		return ts, nil
+	}
+
+	ts := &tokenSource{path: path}
+	if err := ts.reload(); err != nil {
+		return nil, err
+	}
+
+	tokenSources[path] = ts
+	return ts, nil
+}
+
+// tokenFileTransport sets the ACL token of a tokenSource on every request.
+// The file is re-read when Consul doesn't find the token so rotated tokens are picked up.
+type tokenFileTransport struct {
+	base   http.RoundTripper
+	tokens *tokenSource
+}
+
+// newTokenFileTransport wraps base with a tokenFileTransport using the shared token of the file
+func newTokenFileTransport(base http.RoundTripper, path string) (*tokenFileTransport, error) {
+	tokens, err := getTokenSource(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tokenFileTransport{
+		base:   base,
+		tokens: tokens,
+	}, nil
+}
+
+// readTokenFile returns the trimmed content of a token file
+func readTokenFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading token file: %s", err.Error())
+	}
+
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("token file '%s' is empty", path)
+	}
+
+	return token, nil
+}
+
+// reload re-reads the token file
+func (ts *tokenSource) reload() error {
+	token, err := readTokenFile(ts.path)
+	if err != nil {
+		return err
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.token = token
+	return nil
+}
+
+func (ts *tokenSource) current() string {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	return ts.token
+}
+
+// RoundTrip sends the request with the current token, retrying it once with the re-read token
+// if Consul doesn't find the current one
+func (t *tokenFileTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	sent := t.tokens.current()
+	resp, err := t.base.RoundTrip(withToken(req, sent))
+	if err != nil || resp.StatusCode != http.StatusForbidden {
+		return resp, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	if !strings.Contains(string(body), aclNotFound) {
+		return resp, nil
+	}
+
+	// another client may have re-read the file already
+	if t.tokens.current() == sent {
+		if err := t.tokens.reload(); err != nil {
+			log.Warn("Error re-reading token file after '%s': %s", aclNotFound, err.Error())
+			return resp, nil
+		}
+	}
+
+	// the same token would be rejected again, and a consumed body can't be sent twice
+	token := t.tokens.current()
+	if token == sent || (req.Body != nil && req.GetBody == nil) {
+		return resp, nil
+	}
+
+	retry := withToken(req, token)
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Debug("Retrying request to '%s' with the re-read token", req.URL.Path)
+	return t.base.RoundTrip(retry)
+}
+
+// withToken returns a copy of the request that sends token
+func withToken(req *http.Request, token string) *http.Request {
+	out := req.Clone(req.Context())
+	out.Header.Set("X-Consul-Token", token)
+	return out
+}
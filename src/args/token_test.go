@@ -0,0 +1,141 @@
+package args
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/newrelic/nri-consul/src/testutils"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ArgumentList_CreateAPIConfig_TokenFile(t *testing.T) {
+	mux, hostname, port, serverClose := testutils.SetupServer()
+	defer serverClose()
+
+	requests := 0
+	mux.HandleFunc("/v1/status/leader", func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.Header.Get("X-Consul-Token") {
+		case "new_token":
+			fmt.Fprint(w, `"10.0.0.1:8300"`)
+		case "denied_token":
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, "Permission denied")
+		default:
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, "ACL not found")
+		}
+	})
+
+	testCases := []struct {
+		name         string
+		token        string
+		rotatedToken string
+		wantRequests int
+		wantError    bool
+	}{
+		{"Current Token", "new_token", "", 1, false},
+		{"Rotated Token", "old_token", "new_token", 2, false},
+		{"Not Rotated Token", "old_token", "", 1, true},
+		{"Denied Token", "denied_token", "new_token", 1, true},
+	}
+
+	for _, tc := range testCases {
+		tokenFile := filepath.Join(t.TempDir(), "token")
+		require.NoError(t, os.WriteFile(tokenFile, []byte(tc.token+"\n"), 0600), tc.name)
+
+		al := ArgumentList{
+			Hostname:  hostname,
+			Port:      port,
+			Token:     "ignored_token",
+			TokenFile: tokenFile,
+			Timeout:   "30s",
+		}
+
+		apiConfig, err := al.CreateAPIConfig(al.Hostname)
+		require.NoError(t, err, tc.name)
+
+		client, err := api.NewClient(apiConfig)
+		require.NoError(t, err, tc.name)
+
+		if tc.rotatedToken != "" {
+			require.NoError(t, os.WriteFile(tokenFile, []byte(tc.rotatedToken), 0600), tc.name)
+		}
+
+		requests = 0
+		leader, err := client.Status().Leader()
+		require.Equal(t, tc.wantRequests, requests, tc.name)
+		if tc.wantError {
+			require.Error(t, err, tc.name)
+			continue
+		}
+		require.NoError(t, err, tc.name)
+		require.Equal(t, "10.0.0.1:8300", leader, tc.name)
+	}
+}
+
+func Test_ArgumentList_CreateAPIConfig_BadTokenFile(t *testing.T) {
+	emptyFile := filepath.Join(t.TempDir(), "empty")
+	require.NoError(t, os.WriteFile(emptyFile, []byte("\n"), 0600))
+
+	for _, tokenFile := range []string{filepath.Join(t.TempDir(), "missing"), emptyFile} {
+		al := ArgumentList{
+			Hostname:  "localhost",
+			Port:      "8500",
+			TokenFile: tokenFile,
+			Timeout:   "30s",
+		}
+
+		_, err := al.CreateAPIConfig(al.Hostname)
+		require.Error(t, err, tokenFile)
+	}
+}
+
+func Test_ArgumentList_CreateMemberAPIConfig_SharedTokenFile(t *testing.T) {
+	mux, hostname, port, serverClose := testutils.SetupServer()
+	defer serverClose()
+
+	requests := 0
+	mux.HandleFunc("/v1/status/leader", func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("X-Consul-Token") != "new_token" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, "ACL not found")
+			return
+		}
+		fmt.Fprint(w, `"10.0.0.1:8300"`)
+	})
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("old_token"), 0600))
+
+	al := ArgumentList{
+		Port:      port,
+		TokenFile: tokenFile,
+		Timeout:   "30s",
+	}
+
+	clients := make([]*api.Client, 0, 2)
+	for _, name := range []string{"consul-0", "consul-1"} {
+		apiConfig, err := al.CreateMemberAPIConfig(&api.AgentMember{Name: name, Addr: hostname})
+		require.NoError(t, err, name)
+
+		client, err := api.NewClient(apiConfig)
+		require.NoError(t, err, name)
+		clients = append(clients, client)
+	}
+
+	require.NoError(t, os.WriteFile(tokenFile, []byte("new_token"), 0600))
+
+	// the first member re-reads the rotated token, the second one already sends it
+	for idx, wantRequests := range []int{2, 1} {
+		requests = 0
+		_, err := clients[idx].Status().Leader()
+		require.NoError(t, err)
+		require.Equal(t, wantRequests, requests)
+	}
+}
@@ -13,6 +13,8 @@ import (
 	"github.com/newrelic/nri-consul/src/agent"
 	"github.com/newrelic/nri-consul/src/args"
 	"github.com/newrelic/nri-consul/src/datacenter"
+	"github.com/newrelic/nri-consul/src/metrics"
+	"github.com/newrelic/nri-consul/src/permissions"
 )
 
 const (
@@ -49,28 +51,33 @@ func main() {
 	// Setup logging with verbose
 	log.SetupLogging(args.Verbose)
 
-	apiConfig, err := args.CreateAPIConfig(args.Hostname)
+	if err := args.ApplyConsulEnv(); err != nil {
+		log.Error("Error reading Consul environment variables: %s", err.Error())
+		os.Exit(1)
+	}
+
+	defs, err := metricDefinitions(args.MetricDefinitionsFile)
 	if err != nil {
-		log.Error("Error creating HTTP API client, please check configuration: %s", err.Error())
+		log.Error("Error loading metric definitions, please check configuration: %s", err.Error())
 		os.Exit(1)
 	}
 
-	// create client
-	client, err := api.NewClient(apiConfig)
+	clusters, err := args.Clusters()
 	if err != nil {
-		log.Error("Error creating API client, please check configuration: %s", err.Error())
+		log.Error("Error reading clusters, please check configuration: %s", err.Error())
 		os.Exit(1)
 	}
 
-	var collectionError error
-	if args.FanOut {
-		collectionError = fanOutCollection(client, i, &args)
-	} else {
-		collectionError = localCollection(client, i, &args)
+	// a failing cluster doesn't prevent publishing the data of the others
+	failed := 0
+	for _, cluster := range clusters {
+		if err := collectCluster(i, &cluster, defs); err != nil {
+			log.Error("Error collecting metrics%s: %s", clusterSuffix(&cluster), err.Error())
+			failed++
+		}
 	}
 
-	if collectionError != nil {
-		log.Error("Error collecting metrics: %s", collectionError.Error())
+	if failed == len(clusters) {
 		os.Exit(1)
 	}
 
@@ -80,33 +87,132 @@ func main() {
 	}
 }
 
-func fanOutCollection(client *api.Client, i *integration.Integration, args *args.ArgumentList) error {
+// metricDefinitions returns the metric definitions of each sample, the built-in ones
+// merged with the ones of the metric definitions file if there's any
+func metricDefinitions(path string) (map[string]*metrics.Definitions, error) {
+	userDefs := make(map[string]*metrics.Definitions)
+	if path != "" {
+		var err error
+		if userDefs, err = metrics.LoadDefinitions(path); err != nil {
+			return nil, err
+		}
+	}
+
+	agentDefs, err := agent.BuiltInDefinitions().Extend(userDefs[metrics.AgentSample], agent.ReservedNames())
+	if err != nil {
+		return nil, fmt.Errorf("%s sample: %s", metrics.AgentSample, err.Error())
+	}
+
+	dcDefs, err := datacenter.BuiltInDefinitions().Extend(userDefs[metrics.DatacenterSample], datacenter.ReservedNames())
+	if err != nil {
+		return nil, fmt.Errorf("%s sample: %s", metrics.DatacenterSample, err.Error())
+	}
+
+	return map[string]*metrics.Definitions{
+		metrics.AgentSample:      agentDefs,
+		metrics.DatacenterSample: dcDefs,
+	}, nil
+}
+
+// collectCluster collects a Consul cluster into the integration, reading telemetry into the definitions of each sample
+func collectCluster(i *integration.Integration, args *args.ArgumentList, defs map[string]*metrics.Definitions) error {
+	if args.DisableUnixSocketFanOut() {
+		log.Warn("Fan out collection can't reach other agents through the unix socket %s, only collecting the local agent", args.Hostname)
+	}
+
+	if err := args.Validate(); err != nil {
+		return fmt.Errorf("error validating arguments: %s", err.Error())
+	}
+
+	apiConfig, err := args.CreateAPIConfig(args.Hostname)
+	if err != nil {
+		return fmt.Errorf("error creating HTTP API client, please check configuration: %s", err.Error())
+	}
+
+	// create client
+	client, err := api.NewClient(apiConfig)
+	if err != nil {
+		return fmt.Errorf("error creating API client, please check configuration: %s", err.Error())
+	}
+
+	// without CLUSTER_NAME every entity of the cluster is keyed by the name derived from the local datacenter
+	if args.ClusterName == "" {
+		if clusterName, err := datacenter.DefaultClusterName(client); err != nil {
+			log.Warn("Error deriving the cluster name, set CLUSTER_NAME to tell apart the entities of clusters with the same datacenter names: %s", err.Error())
+		} else {
+			args.ClusterName = clusterName
+		}
+	}
+
+	// skip the collectors the token lacks permissions for, listing them once collection is done
+	perms := permissions.New(client)
+	defer perms.SetInventory(i.LocalEntity(), args.ClusterName)
+
+	if args.FanOut {
+		return fanOutCollection(client, i, args, perms, defs)
+	}
+
+	return localCollection(client, i, args, perms, defs)
+}
+
+// clusterSuffix names the cluster in log messages when there are several
+func clusterSuffix(args *args.ArgumentList) string {
+	if args.ClusterName == "" {
+		return ""
+	}
+
+	return fmt.Sprintf(" for cluster '%s'", args.ClusterName)
+}
+
+func fanOutCollection(client *api.Client, i *integration.Integration, args *args.ArgumentList, perms *permissions.Set, defs map[string]*metrics.Definitions) error {
 	// Create the list of agents in LAN pool
 	agents, leader, err := agent.CreateAgents(client, i, args)
 	if err != nil {
 		return fmt.Errorf("Error creating Agent entities: %s", err.Error())
 	}
 
-	dc, err := datacenter.NewDatacenter(leader, i)
-	if err != nil {
+	if leader == nil {
+		log.Warn("No leader elected or the leader isn't a member, skipping Datacenter collection")
+	} else if dc, err := datacenter.NewDatacenter(leader, i, args); err != nil {
 		log.Error("Error creating Datacenter entity: %s", err.Error())
-	} else if args.HasMetrics() {
-		dc.CollectMetrics()
+	} else {
+		collectDatacenters(dc, args, perms, defs[metrics.DatacenterSample])
 	}
 
 	// Collect inventory for agents
 	if args.HasInventory() {
-		agent.CollectInventory(agents)
+		agent.CollectInventory(agents, perms)
 	}
 
 	// Collect metrics for Agents and cluster
 	if args.HasMetrics() {
-		agent.CollectMetrics(agents)
+		agent.CollectMetrics(agents, perms, defs[metrics.AgentSample])
 	}
 
 	return nil
 }
 
+// collectDatacenters collects the local Datacenter and, if enabled, every remote Datacenter reachable through its leader
+func collectDatacenters(dc *datacenter.Datacenter, args *args.ArgumentList, perms *permissions.Set, defs *metrics.Definitions) {
+	dcs := []*datacenter.Datacenter{dc}
+	if args.RemoteDatacenters {
+		remotes, err := dc.RemoteDatacenters()
+		if err != nil {
+			log.Error("Error listing remote Datacenters: %s", err.Error())
+		}
+		dcs = append(dcs, remotes...)
+	}
+
+	for _, dc := range dcs {
+		if args.HasMetrics() {
+			dc.CollectMetrics(perms, defs)
+		}
+		if args.HasInventory() {
+			dc.CollectInventory(perms)
+		}
+	}
+}
+
 func maybeConvertBool(value interface{}) (bool, error) {
 	switch v := value.(type) {
 	default:
@@ -122,7 +228,7 @@ func maybeConvertBool(value interface{}) (bool, error) {
 	}
 }
 
-func localCollection(client *api.Client, i *integration.Integration, args *args.ArgumentList) error {
+func localCollection(client *api.Client, i *integration.Integration, args *args.ArgumentList, perms *permissions.Set, defs map[string]*metrics.Definitions) error {
 	localAgentData, err := client.Agent().Self()
 	if err != nil {
 		return fmt.Errorf("Failed to collect local agent data: %v", err)
@@ -174,30 +280,34 @@ func localCollection(client *api.Client, i *integration.Integration, args *args.
 		isLeader = false
 	}
 
-	agentNameIDAttr := integration.NewIDAttribute("co-agent", memberName)
-	entity, err := i.Entity(fmt.Sprintf("%s:%v", memberAddr, memberPort), "co-agent", agentNameIDAttr)
+	// only needed to key the entity, NewEntity fails if it's required and missing
+	nodeID, _ := localAgentData["Config"]["NodeID"].(string)
+
+	port := fmt.Sprintf("%v", memberPort)
+	entity, err := agent.NewEntity(i, args, memberName, memberAddr, port, nodeID)
 	if err != nil {
 		return fmt.Errorf("failed to create newrelic entity: %v", err)
 	}
-	agentInstance := agent.NewAgent(client, entity, memberName, memberAddr, memberDataCenter)
+	agentInstance := agent.NewAgent(client, entity, memberName, memberAddr, port, memberDataCenter, args.ClusterName)
 
-	if args.HasMetrics() {
-		if isLeader {
-			log.Debug("Checking Leader Metrics")
-			dc, err := datacenter.NewDatacenter(agentInstance, i)
-			if err != nil {
-				log.Error("Failed to get datacenter metrics: %v", err)
-			} else {
-				dc.CollectMetrics()
-			}
+	if isLeader {
+		log.Debug("Checking Leader Metrics")
+		dc, err := datacenter.NewDatacenter(agentInstance, i, args)
+		if err != nil {
+			log.Error("Failed to get datacenter metrics: %v", err)
 		} else {
-			log.Debug("Not Checking Leader Metrics")
+			collectDatacenters(dc, args, perms, defs[metrics.DatacenterSample])
 		}
-		agent.CollectMetricsFromOne(agentInstance)
+	} else {
+		log.Debug("Not Checking Leader Metrics")
+	}
+
+	if args.HasMetrics() {
+		agent.CollectMetricsFromOne(agentInstance, perms, defs[metrics.AgentSample])
 	}
 
 	if args.HasInventory() {
-		agent.CollectInventoryFromOne(agentInstance)
+		agent.CollectInventoryFromOne(agentInstance, perms)
 	}
 
 	return nil
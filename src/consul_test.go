@@ -3,6 +3,7 @@ package main_test
 import (
 	"fmt"
 	"net/http"
+	"path/filepath"
 	"testing"
 
 	"time"
@@ -17,7 +18,13 @@ func Test_ClientTimeoutIsHonored(t *testing.T) {
 	mux, hostname, port, serverClose := testutils.SetupServer()
 	defer serverClose()
 
-	mux.HandleFunc("/v1/agent/members", func(w http.ResponseWriter, r *http.Request) {
+	unixMux, unixHostname, unixServerClose, err := testutils.SetupUnixServer(filepath.Join(t.TempDir(), "consul.sock"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	defer unixServerClose()
+
+	membersHandler := func(w http.ResponseWriter, r *http.Request) {
 		time.Sleep(1 * time.Second)
 		fmt.Fprint(w, `[
 		{
@@ -46,20 +53,37 @@ func Test_ClientTimeoutIsHonored(t *testing.T) {
 			"DelegateCur": 4
 		}
 	]`)
-	})
+	}
+	mux.HandleFunc("/v1/agent/members", membersHandler)
+	unixMux.HandleFunc("/v1/agent/members", membersHandler)
 
 	testCases := []struct {
 		name          string
+		hostname      string
 		timeout       string
 		errorExpected bool
 	}{
 		{
 			name:          "When the timeout is exceeded Then an error is returned",
+			hostname:      hostname,
 			timeout:       "1s",
 			errorExpected: true,
 		},
 		{
 			name:          "When the timeout is not exceeded a correct response is retrieved",
+			hostname:      hostname,
+			timeout:       "2s",
+			errorExpected: false,
+		},
+		{
+			name:          "When the timeout is exceeded on a unix socket Then an error is returned",
+			hostname:      unixHostname,
+			timeout:       "1s",
+			errorExpected: true,
+		},
+		{
+			name:          "When the timeout is not exceeded on a unix socket a correct response is retrieved",
+			hostname:      unixHostname,
 			timeout:       "2s",
 			errorExpected: false,
 		},
@@ -68,7 +92,7 @@ func Test_ClientTimeoutIsHonored(t *testing.T) {
 	for _, tt := range testCases {
 		t.Run(tt.name, func(t *testing.T) {
 			arg := args.ArgumentList{
-				Hostname:  hostname,
+				Hostname:  tt.hostname,
 				Port:      port,
 				EnableSSL: false,
 				Timeout:   tt.timeout,
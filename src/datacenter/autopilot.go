@@ -0,0 +1,71 @@
+package datacenter
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/newrelic/infra-integrations-sdk/v3/data/attribute"
+	"github.com/newrelic/infra-integrations-sdk/v3/data/metric"
+	"github.com/newrelic/nri-consul/src/metrics"
+)
+
+// timeNow is used to compute how long servers have been stable, replaced in tests
+var timeNow = time.Now
+
+// collectAutopilotMetrics reports autopilot's view of the cluster health on the Datacenter sample
+// and the health of each server on its own ConsulServerSample
+func (dc *Datacenter) collectAutopilotMetrics(metricSet *metric.Set) error {
+	health, err := dc.leader.Client.Operator().AutopilotServerHealth(dc.queryOptions)
+	if err != nil {
+		return err
+	}
+
+	metrics.SetMetric(metricSet, "autopilot.healthy", health.Healthy, metric.GAUGE)
+	metrics.SetMetric(metricSet, "autopilot.failureTolerance", health.FailureTolerance, metric.GAUGE)
+
+	// lag is measured against the leader's last index
+	var leaderIndex uint64
+	for _, server := range health.Servers {
+		if server.Leader {
+			leaderIndex = server.LastIndex
+			break
+		}
+	}
+
+	for _, server := range health.Servers {
+		dc.setServerHealthMetrics(server, leaderIndex)
+	}
+
+	return nil
+}
+
+// setServerHealthMetrics creates a ConsulServerSample for a single server
+func (dc *Datacenter) setServerHealthMetrics(server api.ServerHealth, leaderIndex uint64) {
+	metricSet := dc.entity.NewMetricSet("ConsulServerSample",
+		attribute.Attribute{Key: "displayName", Value: dc.entity.Metadata.Name},
+		attribute.Attribute{Key: "entityName", Value: dc.entity.Metadata.Namespace + ":" + dc.entity.Metadata.Name},
+		attribute.Attribute{Key: "serverID", Value: server.ID},
+		attribute.Attribute{Key: "serverName", Value: server.Name},
+		attribute.Attribute{Key: "address", Value: server.Address},
+		attribute.Attribute{Key: "version", Value: server.Version},
+		attribute.Attribute{Key: "serfStatus", Value: server.SerfStatus},
+		attribute.Attribute{Key: "leader", Value: strconv.FormatBool(server.Leader)},
+		attribute.Attribute{Key: "voter", Value: strconv.FormatBool(server.Voter)},
+	)
+
+	var indexLag uint64
+	if leaderIndex > server.LastIndex {
+		indexLag = leaderIndex - server.LastIndex
+	}
+
+	metrics.SetMetric(metricSet, "autopilot.healthy", server.Healthy, metric.GAUGE)
+	metrics.SetMetric(metricSet, "autopilot.lastContactInMilliseconds", float64(server.LastContact.Duration())/float64(time.Millisecond), metric.GAUGE)
+	metrics.SetMetric(metricSet, "autopilot.lastTerm", server.LastTerm, metric.GAUGE)
+	metrics.SetMetric(metricSet, "autopilot.lastIndex", server.LastIndex, metric.GAUGE)
+	metrics.SetMetric(metricSet, "autopilot.lastIndexLag", indexLag, metric.GAUGE)
+
+	if !server.StableSince.IsZero() {
+		metrics.SetMetric(metricSet, "autopilot.stableSinceInSeconds", timeNow().Sub(server.StableSince).Seconds(), metric.GAUGE)
+	}
+}
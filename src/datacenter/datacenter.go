@@ -4,6 +4,7 @@ package datacenter
 import (
 	"errors"
 	"fmt"
+	"sync"
 
 	"github.com/hashicorp/consul/api"
 	"github.com/newrelic/infra-integrations-sdk/v3/data/attribute"
@@ -11,18 +12,35 @@ import (
 	"github.com/newrelic/infra-integrations-sdk/v3/integration"
 	"github.com/newrelic/infra-integrations-sdk/v3/log"
 	"github.com/newrelic/nri-consul/src/agent"
+	"github.com/newrelic/nri-consul/src/args"
 	"github.com/newrelic/nri-consul/src/metrics"
+	"github.com/newrelic/nri-consul/src/permissions"
 )
 
 // Datacenter represents the Datacenter
 // Wraps the leader agent and Datacenter entity
 type Datacenter struct {
-	entity *integration.Entity
-	leader *agent.Agent
+	entity      *integration.Entity
+	leader      *agent.Agent
+	integration *integration.Integration
+	name        string
+	// queryOptions target a remote Datacenter through the leader agent, nil for the local one
+	queryOptions *api.QueryOptions
+	// clusterName is the CLUSTER_NAME argument, empty if it isn't set
+	clusterName string
+	// listServiceInstances lists the catalog instances of every service, see getHealthState
+	listServiceInstances bool
+	// idAttributes are added to the identity of every entity of the cluster
+	idAttributes []integration.IDAttribute
+	// raftConfig is fetched once and shared by the collectors reading the raft peer set
+	raftOnce   sync.Once
+	raftConfig *api.RaftConfiguration
+	raftErr    error
 }
 
-// NewDatacenter creates a new datacenter wrapped around the leader Agent
-func NewDatacenter(leader *agent.Agent, i *integration.Integration) (*Datacenter, error) {
+// NewDatacenter creates a new datacenter wrapped around the leader Agent.
+// The cluster name of al tells apart its entities from the ones of other clusters.
+func NewDatacenter(leader *agent.Agent, i *integration.Integration, al *args.ArgumentList) (*Datacenter, error) {
 	if leader == nil {
 		return nil, errors.New("leader must not be nil")
 	}
@@ -32,17 +50,94 @@ func NewDatacenter(leader *agent.Agent, i *integration.Integration) (*Datacenter
 		return nil, err
 	}
 
-	dcEntity, err := i.Entity(*dcName, "co-datacenter")
+	idAttrs := args.ClusterIDAttributes(al.ClusterName)
+	dcEntity, err := i.Entity(*dcName, "co-datacenter", idAttrs...)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Datacenter{
-		entity: dcEntity,
-		leader: leader,
+		entity:               dcEntity,
+		leader:               leader,
+		integration:          i,
+		name:                 *dcName,
+		clusterName:          al.ClusterName,
+		listServiceInstances: al.ListServiceInstances,
+		idAttributes:         idAttrs,
 	}, nil
 }
 
+// Name returns the name of the Datacenter
+func (dc *Datacenter) Name() string {
+	return dc.name
+}
+
+// RemoteDatacenters creates a Datacenter for every other datacenter known to the catalog.
+// Their catalog, health and raft data is queried through this Datacenter's leader agent.
+func (dc *Datacenter) RemoteDatacenters() ([]*Datacenter, error) {
+	dcNames, err := dc.leader.Client.Catalog().Datacenters()
+	if err != nil {
+		return nil, err
+	}
+
+	remotes := make([]*Datacenter, 0, len(dcNames))
+	for _, dcName := range dcNames {
+		if dcName == dc.name {
+			continue
+		}
+
+		dcEntity, err := dc.integration.Entity(dcName, "co-datacenter", dc.idAttributes...)
+		if err != nil {
+			log.Error("Error creating entity for Datacenter '%s': %s", dcName, err.Error())
+			continue
+		}
+
+		remotes = append(remotes, &Datacenter{
+			entity:               dcEntity,
+			leader:               dc.leader,
+			integration:          dc.integration,
+			name:                 dcName,
+			queryOptions:         &api.QueryOptions{Datacenter: dcName},
+			clusterName:          dc.clusterName,
+			listServiceInstances: dc.listServiceInstances,
+			idAttributes:         dc.idAttributes,
+		})
+	}
+
+	return remotes, nil
+}
+
+// isRemote returns true if the Datacenter is queried through another datacenter's leader agent
+func (dc *Datacenter) isRemote() bool {
+	return dc.queryOptions != nil
+}
+
+// staleQueryOptions are the queryOptions letting any server answer, for reads that don't need to hit the leader
+func (dc *Datacenter) staleQueryOptions() *api.QueryOptions {
+	options := &api.QueryOptions{}
+	if dc.queryOptions != nil {
+		*options = *dc.queryOptions
+	}
+	options.AllowStale = true
+
+	return options
+}
+
+// leaderAddr returns the address of the Datacenter leader
+func (dc *Datacenter) leaderAddr() string {
+	if !dc.isRemote() {
+		return dc.leader.HostPort()
+	}
+
+	leader, err := dc.leader.Client.Status().LeaderWithQueryOptions(dc.queryOptions)
+	if err != nil {
+		log.Error("Error getting leader for Datacenter '%s': %s", dc.name, err.Error())
+		return ""
+	}
+
+	return leader
+}
+
 // getDatacenterName retrieves the Datacenter name from the leader
 func getDatacenterName(client *api.Client) (*string, error) {
 	self, err := client.Agent().Self()
@@ -64,48 +159,138 @@ func getDatacenterName(client *api.Client) (*string, error) {
 	return &dcName, nil
 }
 
-// CollectMetrics collects all datacenter level metrics
-func (dc *Datacenter) CollectMetrics() {
-	metricSet := dc.entity.NewMetricSet("ConsulDatacenterSample",
-		attribute.Attribute{Key: "displayName", Value: dc.entity.Metadata.Name},
-		attribute.Attribute{Key: "entityName", Value: dc.entity.Metadata.Namespace + ":" + dc.entity.Metadata.Name},
-		attribute.Attribute{Key: "leader", Value: dc.leader.HostPort()},
-	)
+// CollectMetrics collects all datacenter level metrics, reading the leader telemetry into defs
+// and skipping the collectors perms doesn't allow
+func (dc *Datacenter) CollectMetrics(perms *permissions.Set, defs *metrics.Definitions) {
+	metricSet := dc.newMetricSet()
 
-	// collect leader agent metrics
-	if err := dc.leader.CollectCoreMetrics(metricSet, nil, counterMetrics, timerMetrics); err != nil {
-		log.Error("Error collecting leader metrics for Datacenter: %s", err.Error())
+	// collect leader agent metrics, the agent telemetry is only available for the local Datacenter
+	if !dc.isRemote() && dc.leader.Permissions(perms).Allowed(permissions.AgentCoreMetrics) {
+		if err := dc.leader.CollectCoreMetrics(metricSet, dc.newMetricSet, defs.Gauges, defs.Counters, defs.Timers); err != nil {
+			log.Error("Error collecting leader metrics for Datacenter: %s", err.Error())
+		}
 	}
 
 	// collect node count
-	if err := dc.setNodeCountMetric(metricSet); err != nil {
-		log.Error("Error collecting node count: %s", err.Error())
+	var nodes []*api.Node
+	if perms.Allowed(permissions.DatacenterCatalog) {
+		var err error
+		if nodes, err = dc.setNodeCountMetric(metricSet, perms); err != nil {
+			log.Error("Error collecting node count: %s", err.Error())
+		}
 	}
 
 	// collect node health counts
-	if err := dc.collectStatusCounts(metricSet); err != nil {
-		log.Error("Error getting node health counts: %s", err.Error())
+	if perms.Allowed(permissions.DatacenterHealth) {
+		if err := dc.collectStatusCounts(metricSet, nodes, perms); err != nil {
+			log.Error("Error getting node health counts: %s", err.Error())
+		}
+	}
+
+	// collect raft peer set
+	if perms.Allowed(permissions.DatacenterRaft) {
+		if err := dc.collectRaftMetrics(metricSet); err != nil {
+			log.Error("Error collecting raft configuration: %s", err.Error())
+		}
+	}
+
+	// collect autopilot health
+	if perms.Allowed(permissions.DatacenterAutopilot) {
+		if err := dc.collectAutopilotMetrics(metricSet); err != nil {
+			log.Error("Error collecting autopilot health: %s", err.Error())
+		}
+	}
+
+	// collect LAN pool membership
+	if !dc.isRemote() && perms.Allowed(permissions.DatacenterMembers) {
+		if err := dc.collectMemberCounts(metricSet); err != nil {
+			log.Error("Error collecting members: %s", err.Error())
+		}
+	}
+
+	// collect WAN pool membership and inter-datacenter latency
+	if perms.Allowed(permissions.DatacenterWAN) {
+		if err := dc.collectWANMemberCounts(metricSet); err != nil {
+			log.Error("Error collecting WAN members: %s", err.Error())
+		}
+
+		if err := dc.collectWANLatencyMetrics(metricSet); err != nil {
+			log.Error("Error collecting WAN latency metrics: %s", err.Error())
+		}
 	}
 }
 
-func (dc *Datacenter) setNodeCountMetric(metricSet *metric.Set) error {
-	nodes, _, err := dc.leader.Client.Catalog().Nodes(nil)
+// newMetricSet creates a ConsulDatacenterSample with the attributes common to every datacenter
+func (dc *Datacenter) newMetricSet(extraAttributes ...attribute.Attribute) *metric.Set {
+	attributes := []attribute.Attribute{
+		{Key: "displayName", Value: dc.entity.Metadata.Name},
+		{Key: "entityName", Value: dc.entity.Metadata.Namespace + ":" + dc.entity.Metadata.Name},
+		{Key: "leader", Value: dc.leaderAddr()},
+	}
+
+	if dc.clusterName != "" {
+		attributes = append(attributes, attribute.Attribute{Key: "clusterName", Value: dc.clusterName})
+	}
+
+	return dc.entity.NewMetricSet("ConsulDatacenterSample", append(attributes, extraAttributes...)...)
+}
+
+// CollectInventory collects all datacenter level inventory, unless perms doesn't allow it
+func (dc *Datacenter) CollectInventory(perms *permissions.Set) {
+	if !perms.Allowed(permissions.DatacenterRaft) {
+		return
+	}
+
+	if err := dc.collectRaftInventory(); err != nil {
+		log.Error("Error collecting raft configuration inventory: %s", err.Error())
+	}
+}
+
+func (dc *Datacenter) setNodeCountMetric(metricSet *metric.Set, perms *permissions.Set) ([]*api.Node, error) {
+	nodes, meta, err := dc.leader.Client.Catalog().Nodes(dc.queryOptions)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	perms.Filtered(permissions.DatacenterCatalog, meta)
 
 	metrics.SetMetric(metricSet, "catalog.registeredNodes", len(nodes), metric.GAUGE)
-	return nil
+	return nodes, nil
 }
 
-// collectStatusCounts aggregates health status across services on a node.
-func (dc *Datacenter) collectStatusCounts(metricSet *metric.Set) error {
-	services, _, err := dc.leader.Client.Catalog().Services(nil)
+// collectStatusCounts counts nodes by the worst status across their node and service checks,
+// and service instances by their aggregated status. Nodes without any checks are counted
+// separately when the catalog nodes are known. Each service is also reported on its own
+// co-service entity.
+func (dc *Datacenter) collectStatusCounts(metricSet *metric.Set, nodes []*api.Node, perms *permissions.Set) error {
+	state, err := dc.getHealthState(perms)
 	if err != nil {
 		return err
 	}
 
 	// keeps track of counts
+	instanceCounts := map[string]int{
+		"critical": 0,
+		"warning":  0,
+		"passing":  0,
+	}
+
+	// for each service look at the instances and count health
+	for service, instances := range state.serviceChecks {
+		serviceHealth := newServiceHealth(service)
+		for key, checks := range instances {
+			status := state.instanceStatus(key, checks)
+			if _, ok := instanceCounts[status]; ok {
+				instanceCounts[status]++
+			}
+
+			serviceHealth.addInstance(key.node, status)
+		}
+
+		if err := dc.collectServiceMetrics(serviceHealth); err != nil {
+			log.Error("Error collecting metrics for service '%s': %s", service, err.Error())
+		}
+	}
+
 	nodeCounts := map[string]int{
 		"critical": 0,
 		"up":       0,
@@ -113,30 +298,37 @@ func (dc *Datacenter) collectStatusCounts(metricSet *metric.Set) error {
 		"passing":  0,
 	}
 
-	// for each service look at the nodes that host it and count health
-	for service := range services {
-		entries, _, err := dc.leader.Client.Health().Service(service, "", false, nil)
-		if err != nil {
-			log.Error("Error getting nodes for service: %s", service)
-			return err
+	statuses := state.nodeStatuses()
+	for _, status := range statuses {
+		switch status {
+		case api.HealthCritical:
+			nodeCounts["critical"]++
+		case api.HealthWarning:
+			nodeCounts["warning"]++
+			nodeCounts["up"]++
+		case api.HealthPassing:
+			nodeCounts["passing"]++
+			nodeCounts["up"]++
 		}
+	}
 
-		for _, entry := range entries {
-			switch entry.Checks.AggregatedStatus() {
-			case api.HealthCritical:
-				nodeCounts["critical"]++
-			case api.HealthWarning:
-				nodeCounts["warning"]++
-			case api.HealthPassing:
-				nodeCounts["up"]++
-				nodeCounts["passing"]++
+	if nodes != nil {
+		unchecked := 0
+		for _, node := range nodes {
+			if _, ok := statuses[node.Node]; !ok {
+				unchecked++
 			}
 		}
+		nodeCounts["unchecked"] = unchecked
 	}
 
 	for status, count := range nodeCounts {
 		metrics.SetMetric(metricSet, fmt.Sprintf("catalog.%sNodes", status), count, metric.GAUGE)
 	}
 
+	for status, count := range instanceCounts {
+		metrics.SetMetric(metricSet, fmt.Sprintf("catalog.%sServiceInstances", status), count, metric.GAUGE)
+	}
+
 	return nil
 }
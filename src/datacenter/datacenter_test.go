@@ -5,11 +5,14 @@ import (
 	"net/http"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/consul/api"
+	"github.com/newrelic/infra-integrations-sdk/v3/data/inventory"
 	"github.com/newrelic/infra-integrations-sdk/v3/integration"
 	"github.com/newrelic/nri-consul/src/agent"
 	"github.com/newrelic/nri-consul/src/args"
+	"github.com/newrelic/nri-consul/src/permissions"
 	"github.com/newrelic/nri-consul/src/testutils"
 )
 
@@ -19,7 +22,7 @@ func TestNewDatacenter_NoLeader(t *testing.T) {
 		t.Fatalf("Unexpected error %s", err.Error())
 	}
 
-	if _, err := NewDatacenter(nil, i); err == nil {
+	if _, err := NewDatacenter(nil, i, &args.ArgumentList{}); err == nil {
 		t.Error("Expected error")
 	}
 
@@ -67,7 +70,7 @@ func TestNewDatacenter_Normal(t *testing.T) {
 		}`)
 	})
 
-	out, err := NewDatacenter(agent, i)
+	out, err := NewDatacenter(agent, i, &args.ArgumentList{})
 	if err != nil {
 		t.Fatalf("Unexpected error %s", err)
 	}
@@ -84,6 +87,170 @@ func TestNewDatacenter_Normal(t *testing.T) {
 
 }
 
+func TestNewDatacenter_ClusterIDAttributes(t *testing.T) {
+	mux, hostname, port, serverClose := testutils.SetupServer()
+	defer serverClose()
+
+	arg := args.ArgumentList{
+		Hostname:  hostname,
+		Port:      port,
+		EnableSSL: false,
+		Timeout:   "0s",
+	}
+
+	apiConfig, err := arg.CreateAPIConfig(arg.Hostname)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	client, err := api.NewClient(apiConfig)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	i, err := integration.New("test", "1.0.0")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	mux.HandleFunc("/v1/agent/self", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"Config": {"Datacenter": "dc1"}}`)
+	})
+
+	mux.HandleFunc("/v1/operator/raft/configuration", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"Servers": [{"ID": "a-server"}, {"ID": "b-server"}]}`)
+	})
+
+	agentEntity, err := i.Entity("leader", "agent")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	leader := agent.NewAgent(client, agentEntity, "consul-0", "10.0.0.1", "8301", "dc1", "")
+
+	east, err := NewDatacenter(leader, i, &args.ArgumentList{ClusterName: "east"})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	west, err := NewDatacenter(leader, i, &args.ArgumentList{ClusterName: "west"})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	if east.entity == west.entity {
+		t.Fatal("Expected a Datacenter entity per cluster")
+	}
+
+	eastKey, err := east.entity.Key()
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	if expected := "co-datacenter:dc1:clustername=east"; eastKey.String() != expected {
+		t.Errorf("Expected entity key %s got %s", expected, eastKey.String())
+	}
+
+	east.CollectMetrics(nil, BuiltInDefinitions())
+	if out := east.entity.Metrics[0].Metrics["clusterName"]; out != "east" {
+		t.Errorf("Expected clusterName east got %v", out)
+	}
+
+	// without a name the entity identity is unchanged
+	unnamed, err := NewDatacenter(leader, i, &args.ArgumentList{})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	unnamedKey, err := unnamed.entity.Key()
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	if expected := "co-datacenter:dc1"; unnamedKey.String() != expected {
+		t.Errorf("Expected entity key %s got %s", expected, unnamedKey.String())
+	}
+
+	unnamed.CollectMetrics(nil, BuiltInDefinitions())
+	if out, ok := unnamed.entity.Metrics[0].Metrics["clusterName"]; ok {
+		t.Errorf("Unexpected clusterName %v", out)
+	}
+}
+
+func TestDefaultClusterName(t *testing.T) {
+	mux, hostname, port, serverClose := testutils.SetupServer()
+	defer serverClose()
+
+	arg := args.ArgumentList{
+		Hostname:  hostname,
+		Port:      port,
+		EnableSSL: false,
+		Timeout:   "0s",
+	}
+
+	apiConfig, err := arg.CreateAPIConfig(arg.Hostname)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	client, err := api.NewClient(apiConfig)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	servers := `[{"ID": "a-server"}, {"ID": "b-server"}]`
+	mux.HandleFunc("/v1/operator/raft/configuration", func(w http.ResponseWriter, r *http.Request) {
+		// any server of the local datacenter can answer
+		if _, ok := r.URL.Query()["stale"]; !ok || r.URL.Query().Get("dc") != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, `{"Servers": %s}`, servers)
+	})
+
+	out, err := DefaultClusterName(client)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	if expected := "consul-27ed1426c165"; out != expected {
+		t.Errorf("Expected %s got %s", expected, out)
+	}
+
+	servers = `[]`
+	if _, err := DefaultClusterName(client); err == nil {
+		t.Error("Expected an error without raft servers")
+	}
+}
+
+func Test_defaultClusterName(t *testing.T) {
+	config := func(ids ...string) *api.RaftConfiguration {
+		out := &api.RaftConfiguration{}
+		for _, id := range ids {
+			out.Servers = append(out.Servers, &api.RaftServer{ID: id})
+		}
+		return out
+	}
+
+	first := defaultClusterName(config("a-server", "b-server"))
+	if expected := "consul-27ed1426c165"; first != expected {
+		t.Errorf("Expected %s got %s", expected, first)
+	}
+
+	// the name doesn't depend on the order of the servers
+	if out := defaultClusterName(config("b-server", "a-server")); out != first {
+		t.Errorf("Expected %s got %s", first, out)
+	}
+
+	if out := defaultClusterName(config("a-server", "c-server")); out == first {
+		t.Errorf("Expected a different name than %s", first)
+	}
+
+	if out := defaultClusterName(config()); out != "" {
+		t.Errorf("Expected no name for an empty raft peer set got %s", out)
+	}
+}
+
 func TestNewDatacenter_DCName_Config_Failure(t *testing.T) {
 	mux, hostname, port, serverClose := testutils.SetupServer()
 	defer serverClose()
@@ -120,7 +287,7 @@ func TestNewDatacenter_DCName_Config_Failure(t *testing.T) {
 		}`)
 	})
 
-	if _, err := NewDatacenter(agent, i); err == nil {
+	if _, err := NewDatacenter(agent, i, &args.ArgumentList{}); err == nil {
 		t.Error("Expected error")
 	}
 }
@@ -161,7 +328,7 @@ func TestNewDatacenter_DCName_Failure(t *testing.T) {
 		}`)
 	})
 
-	if _, err := NewDatacenter(agent, i); err == nil {
+	if _, err := NewDatacenter(agent, i, &args.ArgumentList{}); err == nil {
 		t.Error("Expected error")
 	}
 }
@@ -200,7 +367,7 @@ func TestNewDatacenter_Leader_Self_Failure(t *testing.T) {
 		w.WriteHeader(http.StatusNotFound)
 	})
 
-	if _, err := NewDatacenter(agent, i); err == nil {
+	if _, err := NewDatacenter(agent, i, &args.ArgumentList{}); err == nil {
 		t.Error("Expected error")
 	}
 }
@@ -242,38 +409,91 @@ func Test_Datacenter_CollectMetrics_Full(t *testing.T) {
 	}
 
 	c := &Datacenter{
-		entity: dcEntity,
-		leader: agent.NewAgent(client, agentEntity, "", "", ""),
+		entity:               dcEntity,
+		leader:               agent.NewAgent(client, agentEntity, "leader", "10.0.0.1", "8301", "dc1", ""),
+		integration:          i,
+		name:                 "test",
+		listServiceInstances: true,
 	}
 
 	setMetricMuxes(mux)
 
 	expected := map[string]interface{}{
-		"event_type":                       "ConsulDatacenterSample",
-		"displayName":                      c.entity.Metadata.Name,
-		"entityName":                       c.entity.Metadata.Namespace + ":" + c.entity.Metadata.Name,
-		"leader":                           "leader",
-		"raft.txns":                        float64(0),
-		"raft.commitTimeAvgInMilliseconds": float64(3),
-		"raft.commitTimes":                 float64(0),
-		"raft.commitTimeMaxInMilliseconds": float64(5),
-		"catalog.registeredNodes":          float64(3),
-		"catalog.criticalNodes":            float64(1),
-		"catalog.upNodes":                  float64(1),
-		"catalog.warningNodes":             float64(1),
-		"catalog.passingNodes":             float64(1),
+		"event_type":                             "ConsulDatacenterSample",
+		"displayName":                            c.entity.Metadata.Name,
+		"entityName":                             c.entity.Metadata.Namespace + ":" + c.entity.Metadata.Name,
+		"leader":                                 "10.0.0.1:8301",
+		"raft.txns":                              float64(0.2),
+		"raft.commitTimeAvgInMilliseconds":       float64(3),
+		"raft.commitTimes":                       float64(0.1),
+		"raft.commitTimeMaxInMilliseconds":       float64(5),
+		"raft.commitTimeMinInMilliseconds":       float64(1),
+		"raft.commitTimeStddevInMilliseconds":    float64(0),
+		"raft.commitTimeSumInMilliseconds":       float64(5),
+		"raft.commitTimeInMillisecondsPerSecond": float64(0.5),
+		"catalog.registeredNodes":                float64(3),
+		"catalog.criticalNodes":                  float64(1),
+		"catalog.upNodes":                        float64(2),
+		"catalog.warningNodes":                   float64(1),
+		"catalog.passingNodes":                   float64(1),
+		"catalog.uncheckedNodes":                 float64(1),
+		"catalog.criticalServiceInstances":       float64(1),
+		"catalog.warningServiceInstances":        float64(2),
+		"catalog.passingServiceInstances":        float64(2),
+		"raft.servers":                           float64(3),
+		"raft.voters":                            float64(2),
+		"raft.nonVoters":                         float64(1),
+		"raft.protocolVersions":                  "3",
+		"autopilot.healthy":                      float64(0),
+		"autopilot.failureTolerance":             float64(0),
+		"wan.members.alive":                      float64(1),
+		"wan.members.leaving":                    float64(0),
+		"wan.members.left":                       float64(0),
+		"wan.members.failed":                     float64(1),
+		"net.wan.minLatencyInMilliseconds":       float64(375),
+		"net.wan.medianLatencyInMilliseconds":    float64(500),
+		"net.wan.p99LatencyInMilliseconds":       float64(625),
+		"members.alive":                          float64(2),
+		"members.leaving":                        float64(1),
+		"members.left":                           float64(1),
+		"members.failed":                         float64(1),
+		"members.server.alive":                   float64(1),
+		"members.server.leaving":                 float64(1),
+		"members.server.left":                    float64(0),
+		"members.server.failed":                  float64(1),
+		"members.client.alive":                   float64(1),
+		"members.client.leaving":                 float64(0),
+		"members.client.left":                    float64(1),
+		"members.client.failed":                  float64(0),
 	}
 
-	c.CollectMetrics()
+	c.CollectMetrics(nil, BuiltInDefinitions())
 
 	result := c.entity.Metrics[0].Metrics
 	if !reflect.DeepEqual(result, expected) {
 		t.Errorf("Expected %+v got %+v", expected, result)
 	}
+
+	// metric definitions can't reuse any name the collectors or the built-in definitions report
+	reserved := make(map[string]bool)
+	for _, name := range ReservedNames() {
+		reserved[name] = true
+	}
+	for _, def := range counterMetrics {
+		reserved[def.MetricName] = true
+	}
+	for _, def := range timerMetrics {
+		reserved[def.MetricName] = true
+	}
+	for name := range result {
+		if !reserved[name] {
+			t.Errorf("Expected %s to be reserved", name)
+		}
+	}
 }
 
-func Test_Datacenter_CollectMetrics_All_Endpoint_Fails(t *testing.T) {
-	_, hostname, port, serverClose := testutils.SetupServer()
+func Test_Datacenter_CollectMetrics_MissingPermissions(t *testing.T) {
+	mux, hostname, port, serverClose := testutils.SetupServer()
 	defer serverClose()
 
 	arg := args.ArgumentList{
@@ -309,291 +529,1616 @@ func Test_Datacenter_CollectMetrics_All_Endpoint_Fails(t *testing.T) {
 	}
 
 	c := &Datacenter{
-		entity: dcEntity,
-		leader: agent.NewAgent(client, agentEntity, "", "", ""),
+		entity:      dcEntity,
+		leader:      agent.NewAgent(client, agentEntity, "leader", "10.0.0.1", "8301", "dc1", ""),
+		integration: i,
+		name:        "test",
 	}
 
-	expected := map[string]interface{}{
-		"event_type":  "ConsulDatacenterSample",
-		"displayName": c.entity.Metadata.Name,
-		"entityName":  c.entity.Metadata.Namespace + ":" + c.entity.Metadata.Name,
-		"leader":      "leader",
+	setMetricMuxes(mux)
+
+	// the collectors are probed against a server where the token can't read the operator endpoints
+	probeMux, probeHostname, probePort, probeServerClose := testutils.SetupServer()
+	defer probeServerClose()
+
+	denied := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "Permission denied: token lacks permission 'operator:read'")
+	}
+	probeMux.HandleFunc("/v1/operator/raft/configuration", denied)
+	probeMux.HandleFunc("/v1/operator/autopilot/health", denied)
+
+	probeArg := args.ArgumentList{
+		Hostname: probeHostname,
+		Port:     probePort,
+		Timeout:  "0s",
+	}
+
+	probeConfig, err := probeArg.CreateAPIConfig(probeArg.Hostname)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	probeClient, err := api.NewClient(probeConfig)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
 	}
 
-	c.CollectMetrics()
+	perms := permissions.New(probeClient)
+
+	c.CollectMetrics(perms, BuiltInDefinitions())
+	c.CollectInventory(perms)
 
 	result := c.entity.Metrics[0].Metrics
-	if !reflect.DeepEqual(result, expected) {
-		t.Errorf("Expected %+v got %+v", expected, result)
+	for _, key := range []string{"raft.servers", "raft.voters", "autopilot.healthy"} {
+		if _, ok := result[key]; ok {
+			t.Errorf("Unexpected metric %s for a collector without permissions", key)
+		}
 	}
-}
 
-func setMetricMuxes(mux *http.ServeMux) {
-	mux.HandleFunc("/v1/agent/metrics", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprint(w, `{
-			"Timestamp": "2018-10-26 14:17:50 +0000 UTC",
-			"Gauges": [],
-			"Points": [],
-			"Counters": [
-				{
-					"Name": "consul.raft.apply",
-					"Count": 2,
-					"Rate": 0.2,
-					"Sum": 2,
-					"Min": 1,
-					"Max": 1,
-					"Mean": 1,
-					"Stddev": 0,
-					"Labels": {}
-				}
-			],
-			"Samples": [
-				{
-					"Name": "consul.raft.commitTime",
-					"Count": 1,
-					"Rate": 0.16021829843521118,
-					"Sum": 5,
-					"Min": 1,
-					"Max": 5,
-					"Mean": 3,
-					"Stddev": 0,
-					"Labels": {}
-				}
-			]
-		}`)
-	})
+	if result["catalog.registeredNodes"] != float64(3) {
+		t.Errorf("Expected catalog.registeredNodes 3 got %v", result["catalog.registeredNodes"])
+	}
 
-	mux.HandleFunc("/v1/catalog/nodes", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprint(w, `[
-			{
-				"ID": "1234",
-				"Node": "consul-dev-0",
-				"Address": "10.0.0.140",
-				"Datacenter": "dev",
-				"TaggedAddresses": {
-					"lan": "10.0.0.140",
-					"wan": "10.0.0.140"
-				},
-				"Meta": {
-					"consul-network-segment": ""
-				},
-				"CreateIndex": 8,
-				"ModifyIndex": 9
-			},
-			{
-				"ID": "5678",
-				"Node": "consul-dev-1",
-				"Address": "10.0.0.142",
-				"Datacenter": "dev",
-				"TaggedAddresses": {
-					"lan": "10.0.0.142",
-					"wan": "10.0.0.142"
-				},
-				"Meta": {
-					"consul-network-segment": ""
-				},
-				"CreateIndex": 65,
-				"ModifyIndex": 67
-			},
-			{
-				"ID": "91011",
-				"Node": "vault-dev-1",
-				"Address": "10.0.0.55",
-				"Datacenter": "dev",
-				"TaggedAddresses": {
-					"lan": "10.0.0.55",
-					"wan": "10.0.0.55"
-				},
-				"Meta": {
-					"consul-network-segment": ""
-				},
-				"CreateIndex": 6,
-				"ModifyIndex": 7
-			}
-		]`)
-	})
+	// only the datacenter sample, without the per server autopilot samples
+	if len(c.entity.Metrics) != 1 {
+		t.Errorf("Expected 1 metric set got %d", len(c.entity.Metrics))
+	}
 
-	mux.HandleFunc("/v1/catalog/services", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprint(w, `{
-			"consul": [],
-			"vault": [
-				"active"
-			]
-		}`)
-	})
+	if len(c.entity.Inventory.Items()) != 0 {
+		t.Errorf("Unexpected inventory %+v for a collector without permissions", c.entity.Inventory.Items())
+	}
+}
 
-	mux.HandleFunc("/v1/health/service/vault", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprint(w, `[
-			{
-				"Node": {
-					"ID": "91011",
-					"Node": "vault-dev-0",
-					"Address": "10.0.0.55",
-					"Datacenter": "dev",
-					"TaggedAddresses": {
-						"lan": "10.0.0.55",
-						"wan": "10.0.0.55"
-					},
-					"Meta": {
-						"consul-network-segment": ""
-					},
-					"CreateIndex": 20183,
-					"ModifyIndex": 20185
-				},
-				"Service": {
-					"ID": "vault:vault-dev-0.consul.localnet:8200",
-					"Service": "vault",
-					"Tags": [
-						"active"
-					],
-					"Address": "vault-dev-0.consul.localnet",
-					"Meta": null,
-					"Port": 8200,
-					"EnableTagOverride": false,
-					"ProxyDestination": "",
-					"Connect": {
-						"Native": false,
-						"Proxy": null
-					},
-					"CreateIndex": 20259,
-					"ModifyIndex": 20328
-				},
-				"Checks": [
-					{
-						"Node": "vault-dev-0",
-						"CheckID": "serfHealth",
-						"Name": "Serf Health Status",
-						"Status": "warning",
-						"Notes": "",
-						"Output": "Agent alive and reachable",
-						"ServiceID": "",
-						"ServiceName": "",
-						"ServiceTags": [],
-						"Definition": {},
-						"CreateIndex": 20183,
-						"ModifyIndex": 20183
-					},
-					{
-						"Node": "vault-dev-0",
-						"CheckID": "vault:vault-dev-0.consul.localnet:8200:vault-sealed-check",
-						"Name": "Vault Sealed Status",
-						"Status": "passing",
-						"Notes": "Vault service is healthy when Vault is in an unsealed status and can become an active Vault server",
-						"Output": "Vault Unsealed",
-						"ServiceID": "vault:vault-dev-0.consul.localnet:8200",
-						"ServiceName": "vault",
-						"ServiceTags": [
-							"active"
-						],
-						"Definition": {},
-						"CreateIndex": 20260,
-						"ModifyIndex": 20329
-					}
-				]
-			}
-		]`)
-	})
+func Test_Datacenter_CollectMetrics_Services(t *testing.T) {
+	mux, hostname, port, serverClose := testutils.SetupServer()
+	defer serverClose()
 
-	mux.HandleFunc("/v1/health/service/consul", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprint(w, `[
-			{
-				"Node": {
+	arg := args.ArgumentList{
+		Hostname:  hostname,
+		Port:      port,
+		EnableSSL: false,
+		Timeout:   "0s",
+	}
+
+	apiConfig, err := arg.CreateAPIConfig(arg.Hostname)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	client, err := api.NewClient(apiConfig)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	i, err := integration.New("test", "1.0.0")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	dcEntity, err := i.Entity("test", "datacenter")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	agentEntity, err := i.Entity("leader", "agent")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	c := &Datacenter{
+		entity:               dcEntity,
+		leader:               agent.NewAgent(client, agentEntity, "leader", "10.0.0.1", "8301", "dc1", ""),
+		integration:          i,
+		name:                 "test",
+		listServiceInstances: true,
+	}
+
+	setMetricMuxes(mux)
+
+	expected := map[string]map[string]interface{}{
+		"consul": {
+			"event_type":                "ConsulServiceSample",
+			"displayName":               "consul",
+			"entityName":                "co-service:consul",
+			"serviceName":               "consul",
+			"datacenter":                "test",
+			"service.instances":         float64(2),
+			"service.passingInstances":  float64(1),
+			"service.warningInstances":  float64(0),
+			"service.criticalInstances": float64(1),
+			"service.healthyFraction":   float64(0.5),
+			"service.nodes":             float64(2),
+		},
+		"web": {
+			"event_type":                "ConsulServiceSample",
+			"displayName":               "web",
+			"entityName":                "co-service:web",
+			"serviceName":               "web",
+			"datacenter":                "test",
+			"service.instances":         float64(2),
+			"service.passingInstances":  float64(1),
+			"service.warningInstances":  float64(1),
+			"service.criticalInstances": float64(0),
+			"service.healthyFraction":   float64(0.5),
+			"service.nodes":             float64(2),
+		},
+		"vault": {
+			"event_type":                "ConsulServiceSample",
+			"displayName":               "vault",
+			"entityName":                "co-service:vault",
+			"serviceName":               "vault",
+			"datacenter":                "test",
+			"service.instances":         float64(1),
+			"service.passingInstances":  float64(0),
+			"service.warningInstances":  float64(1),
+			"service.criticalInstances": float64(0),
+			"service.healthyFraction":   float64(0),
+			"service.nodes":             float64(1),
+		},
+	}
+
+	c.CollectMetrics(nil, BuiltInDefinitions())
+
+	found := 0
+	for _, entity := range i.Entities {
+		if entity.Metadata == nil || entity.Metadata.Namespace != "co-service" {
+			continue
+		}
+
+		found++
+		want, ok := expected[entity.Metadata.Name]
+		if !ok {
+			t.Errorf("Unexpected service entity %s", entity.Metadata.Name)
+			continue
+		}
+
+		result := entity.Metrics[0].Metrics
+		if !reflect.DeepEqual(result, want) {
+			t.Errorf("Expected %+v got %+v", want, result)
+		}
+	}
+
+	if found != len(expected) {
+		t.Errorf("Expected %d service entities got %d", len(expected), found)
+	}
+}
+
+func Test_Datacenter_CollectMetrics_PartialServices(t *testing.T) {
+	mux, hostname, port, serverClose := testutils.SetupServer()
+	defer serverClose()
+
+	arg := args.ArgumentList{
+		Hostname:  hostname,
+		Port:      port,
+		EnableSSL: false,
+		Timeout:   "0s",
+	}
+
+	apiConfig, err := arg.CreateAPIConfig(arg.Hostname)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	client, err := api.NewClient(apiConfig)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	i, err := integration.New("test", "1.0.0")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	dcEntity, err := i.Entity("test", "datacenter")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	agentEntity, err := i.Entity("leader", "agent")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	c := &Datacenter{
+		entity:               dcEntity,
+		leader:               agent.NewAgent(client, agentEntity, "leader", "10.0.0.1", "8301", "dc1", ""),
+		integration:          i,
+		name:                 "test",
+		listServiceInstances: true,
+	}
+
+	mux.HandleFunc("/v1/health/state/any", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[
+			{
+				"Node": "vault-dev-0",
+				"CheckID": "vault-sealed-check",
+				"Status": "passing",
+				"ServiceID": "vault",
+				"ServiceName": "vault"
+			}
+		]`)
+	})
+
+	// listing the instances of both services fails, "vault" keeps the instance found in its checks
+	mux.HandleFunc("/v1/catalog/services", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{
+			"broken": [],
+			"vault": []
+		}`)
+	})
+
+	expected := map[string]interface{}{
+		"event_type":                       "ConsulDatacenterSample",
+		"displayName":                      c.entity.Metadata.Name,
+		"entityName":                       c.entity.Metadata.Namespace + ":" + c.entity.Metadata.Name,
+		"leader":                           "10.0.0.1:8301",
+		"catalog.criticalNodes":            float64(0),
+		"catalog.upNodes":                  float64(1),
+		"catalog.warningNodes":             float64(0),
+		"catalog.passingNodes":             float64(1),
+		"catalog.criticalServiceInstances": float64(0),
+		"catalog.warningServiceInstances":  float64(0),
+		"catalog.passingServiceInstances":  float64(1),
+	}
+
+	c.CollectMetrics(nil, BuiltInDefinitions())
+
+	result := c.entity.Metrics[0].Metrics
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %+v got %+v", expected, result)
+	}
+}
+
+func Test_Datacenter_CollectMetrics_MixedServiceChecks(t *testing.T) {
+	mux, hostname, port, serverClose := testutils.SetupServer()
+	defer serverClose()
+
+	arg := args.ArgumentList{
+		Hostname:  hostname,
+		Port:      port,
+		EnableSSL: false,
+		Timeout:   "0s",
+	}
+
+	apiConfig, err := arg.CreateAPIConfig(arg.Hostname)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	client, err := api.NewClient(apiConfig)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	i, err := integration.New("test", "1.0.0")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	dcEntity, err := i.Entity("test", "datacenter")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	agentEntity, err := i.Entity("leader", "agent")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	c := &Datacenter{
+		entity:               dcEntity,
+		leader:               agent.NewAgent(client, agentEntity, "leader", "10.0.0.1", "8301", "dc1", ""),
+		integration:          i,
+		name:                 "test",
+		listServiceInstances: true,
+	}
+
+	// api-2 has no service checks so it only shows up in the catalog listing
+	mux.HandleFunc("/v1/health/state/any", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[
+			{"Node": "node-0", "CheckID": "serfHealth", "Status": "passing"},
+			{"Node": "node-1", "CheckID": "serfHealth", "Status": "passing"},
+			{"Node": "node-2", "CheckID": "serfHealth", "Status": "passing"},
+			{"Node": "node-0", "CheckID": "service:api-0", "Status": "passing", "ServiceID": "api-0", "ServiceName": "api"},
+			{"Node": "node-1", "CheckID": "service:api-1", "Status": "critical", "ServiceID": "api-1", "ServiceName": "api"}
+		]`)
+	})
+
+	mux.HandleFunc("/v1/catalog/services", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"api": []}`)
+	})
+
+	mux.HandleFunc("/v1/catalog/service/api", func(w http.ResponseWriter, r *http.Request) {
+		// any server can answer the listings, they don't need to reach the leader
+		if _, ok := r.URL.Query()["stale"]; !ok {
+			t.Error("Expected a stale catalog listing")
+		}
+		fmt.Fprint(w, `[
+			{"Node": "node-0", "ServiceID": "api-0", "ServiceName": "api"},
+			{"Node": "node-1", "ServiceID": "api-1", "ServiceName": "api"},
+			{"Node": "node-2", "ServiceID": "api-2", "ServiceName": "api"}
+		]`)
+	})
+
+	expected := map[string]interface{}{
+		"event_type":                "ConsulServiceSample",
+		"displayName":               "api",
+		"entityName":                "co-service:api",
+		"serviceName":               "api",
+		"datacenter":                "test",
+		"service.instances":         float64(3),
+		"service.passingInstances":  float64(2),
+		"service.warningInstances":  float64(0),
+		"service.criticalInstances": float64(1),
+		"service.healthyFraction":   float64(2) / float64(3),
+		"service.nodes":             float64(3),
+	}
+
+	c.CollectMetrics(nil, BuiltInDefinitions())
+
+	found := false
+	for _, entity := range i.Entities {
+		if entity.Metadata == nil || entity.Metadata.Namespace != "co-service" {
+			continue
+		}
+
+		found = true
+		result := entity.Metrics[0].Metrics
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("Expected %+v got %+v", expected, result)
+		}
+	}
+
+	if !found {
+		t.Error("Expected a service entity for api")
+	}
+}
+
+func Test_Datacenter_CollectMetrics_ServiceInstancesFromChecks(t *testing.T) {
+	mux, hostname, port, serverClose := testutils.SetupServer()
+	defer serverClose()
+
+	arg := args.ArgumentList{
+		Hostname:  hostname,
+		Port:      port,
+		EnableSSL: false,
+		Timeout:   "0s",
+	}
+
+	apiConfig, err := arg.CreateAPIConfig(arg.Hostname)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	client, err := api.NewClient(apiConfig)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	i, err := integration.New("test", "1.0.0")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	dcEntity, err := i.Entity("test", "datacenter")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	agentEntity, err := i.Entity("leader", "agent")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	c := &Datacenter{
+		entity:      dcEntity,
+		leader:      agent.NewAgent(client, agentEntity, "leader", "10.0.0.1", "8301", "dc1", ""),
+		integration: i,
+		name:        "test",
+	}
+
+	mux.HandleFunc("/v1/health/state/any", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[
+			{"Node": "node-0", "CheckID": "service:api-0", "Status": "passing", "ServiceID": "api-0", "ServiceName": "api"},
+			{"Node": "node-1", "CheckID": "service:api-1", "Status": "critical", "ServiceID": "api-1", "ServiceName": "api"}
+		]`)
+	})
+
+	// without listServiceInstances the catalog isn't listed, one request per service
+	catalogRequests := 0
+	mux.HandleFunc("/v1/catalog/", func(w http.ResponseWriter, r *http.Request) {
+		catalogRequests++
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	c.CollectMetrics(nil, BuiltInDefinitions())
+
+	if catalogRequests != 1 {
+		t.Errorf("Expected only the catalog nodes request got %d", catalogRequests)
+	}
+
+	for _, entity := range i.Entities {
+		if entity.Metadata == nil || entity.Metadata.Namespace != "co-service" {
+			continue
+		}
+
+		if out := entity.Metrics[0].Metrics["service.instances"]; out != float64(2) {
+			t.Errorf("Expected 2 instances got %v", out)
+		}
+	}
+}
+
+func Test_Datacenter_CollectMetrics_Maintenance(t *testing.T) {
+	mux, hostname, port, serverClose := testutils.SetupServer()
+	defer serverClose()
+
+	arg := args.ArgumentList{
+		Hostname:  hostname,
+		Port:      port,
+		EnableSSL: false,
+		Timeout:   "0s",
+	}
+
+	apiConfig, err := arg.CreateAPIConfig(arg.Hostname)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	client, err := api.NewClient(apiConfig)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	i, err := integration.New("test", "1.0.0")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	dcEntity, err := i.Entity("test", "datacenter")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	agentEntity, err := i.Entity("leader", "agent")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	c := &Datacenter{
+		entity:               dcEntity,
+		leader:               agent.NewAgent(client, agentEntity, "leader", "10.0.0.1", "8301", "dc1", ""),
+		integration:          i,
+		name:                 "test",
+		listServiceInstances: true,
+	}
+
+	// node-0 is in maintenance, and so is the api-0 instance running on node-1
+	mux.HandleFunc("/v1/health/state/any", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[
+			{"Node": "node-0", "CheckID": "_node_maintenance", "Status": "maintenance"},
+			{"Node": "node-1", "CheckID": "serfHealth", "Status": "passing"},
+			{"Node": "node-2", "CheckID": "serfHealth", "Status": "passing"},
+			{"Node": "node-1", "CheckID": "_service_maintenance:api-0", "Status": "maintenance", "ServiceID": "api-0", "ServiceName": "api"}
+		]`)
+	})
+
+	mux.HandleFunc("/v1/catalog/services", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"api": []}`)
+	})
+
+	mux.HandleFunc("/v1/catalog/service/api", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[
+			{"Node": "node-1", "ServiceID": "api-0", "ServiceName": "api"},
+			{"Node": "node-2", "ServiceID": "api-1", "ServiceName": "api"}
+		]`)
+	})
+
+	expected := map[string]interface{}{
+		"event_type":                       "ConsulDatacenterSample",
+		"displayName":                      c.entity.Metadata.Name,
+		"entityName":                       c.entity.Metadata.Namespace + ":" + c.entity.Metadata.Name,
+		"leader":                           "10.0.0.1:8301",
+		"catalog.criticalNodes":            float64(2),
+		"catalog.upNodes":                  float64(1),
+		"catalog.warningNodes":             float64(0),
+		"catalog.passingNodes":             float64(1),
+		"catalog.criticalServiceInstances": float64(1),
+		"catalog.warningServiceInstances":  float64(0),
+		"catalog.passingServiceInstances":  float64(1),
+	}
+
+	c.CollectMetrics(nil, BuiltInDefinitions())
+
+	result := c.entity.Metrics[0].Metrics
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %+v got %+v", expected, result)
+	}
+}
+
+func Test_Datacenter_CollectInventory_Raft(t *testing.T) {
+	mux, hostname, port, serverClose := testutils.SetupServer()
+	defer serverClose()
+
+	arg := args.ArgumentList{
+		Hostname:  hostname,
+		Port:      port,
+		EnableSSL: false,
+		Timeout:   "0s",
+	}
+
+	apiConfig, err := arg.CreateAPIConfig(arg.Hostname)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	client, err := api.NewClient(apiConfig)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	i, err := integration.New("test", "1.0.0")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	dcEntity, err := i.Entity("test", "datacenter")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	agentEntity, err := i.Entity("leader", "agent")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	c := &Datacenter{
+		entity:      dcEntity,
+		leader:      agent.NewAgent(client, agentEntity, "leader", "10.0.0.1", "8301", "dc1", ""),
+		integration: i,
+		name:        "test",
+	}
+
+	setMetricMuxes(mux)
+
+	expected := inventory.Items{
+		"raft/servers/fbfe7e9b-5d30-284b-cc05-d2d5cc43688d": inventory.Item{
+			"id":              "fbfe7e9b-5d30-284b-cc05-d2d5cc43688d",
+			"node":            "consul-dev-0",
+			"address":         "10.0.0.140:8300",
+			"leader":          true,
+			"voter":           true,
+			"protocolVersion": "3",
+		},
+		"raft/servers/c7f88fba-f8d9-94a9-3627-523398acf7db": inventory.Item{
+			"id":              "c7f88fba-f8d9-94a9-3627-523398acf7db",
+			"node":            "consul-dev-1",
+			"address":         "10.0.0.142:8300",
+			"leader":          false,
+			"voter":           true,
+			"protocolVersion": "3",
+		},
+		"raft/servers/8b4b2a1c-0c65-4a4f-a4a4-2a0e0c2c8b51": inventory.Item{
+			"id":              "8b4b2a1c-0c65-4a4f-a4a4-2a0e0c2c8b51",
+			"node":            "consul-dev-2",
+			"address":         "10.0.0.143:8300",
+			"leader":          false,
+			"voter":           false,
+			"protocolVersion": "3",
+		},
+	}
+
+	c.CollectInventory(nil)
+
+	out := c.entity.Inventory.Items()
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("Expected %+v got %+v", expected, out)
+	}
+}
+
+func Test_Datacenter_RaftConfigurationFetchedOnce(t *testing.T) {
+	mux, hostname, port, serverClose := testutils.SetupServer()
+	defer serverClose()
+
+	arg := args.ArgumentList{
+		Hostname:  hostname,
+		Port:      port,
+		EnableSSL: false,
+		Timeout:   "0s",
+	}
+
+	apiConfig, err := arg.CreateAPIConfig(arg.Hostname)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	client, err := api.NewClient(apiConfig)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	i, err := integration.New("test", "1.0.0")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	dcEntity, err := i.Entity("test", "datacenter")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	agentEntity, err := i.Entity("leader", "agent")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	c := &Datacenter{
+		entity:      dcEntity,
+		leader:      agent.NewAgent(client, agentEntity, "leader", "10.0.0.1", "8301", "dc1", ""),
+		integration: i,
+		name:        "test",
+	}
+
+	requests := 0
+	mux.HandleFunc("/v1/operator/raft/configuration", func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprint(w, `{"Servers": [
+			{"ID": "a", "Node": "consul-dev-0", "Voter": true, "ProtocolVersion": "3"},
+			{"ID": "b", "Node": "consul-dev-1", "Voter": true, "ProtocolVersion": "3"},
+			{"ID": "c", "Node": "consul-dev-2", "Voter": true, "ProtocolVersion": "2"}
+		]}`)
+	})
+
+	c.CollectMetrics(nil, BuiltInDefinitions())
+	c.CollectInventory(nil)
+
+	if requests != 1 {
+		t.Errorf("Expected the raft configuration to be fetched once, got %d requests", requests)
+	}
+
+	if versions := c.entity.Metrics[0].Metrics["raft.protocolVersions"]; versions != "2,3" {
+		t.Errorf("Expected raft.protocolVersions 2,3 got %v", versions)
+	}
+
+	// each protocol version gets its own metric set so a split can be alerted on
+	expected := map[string]float64{"2": 1, "3": 2}
+	out := make(map[string]float64)
+	for _, metricSet := range c.entity.Metrics {
+		if version, ok := metricSet.Metrics["protocolVersion"].(string); ok {
+			out[version] = metricSet.Metrics["raft.serversByProtocolVersion"].(float64)
+		}
+	}
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("Expected servers by protocol version %+v got %+v", expected, out)
+	}
+
+	if items := c.entity.Inventory.Items(); len(items) != 3 {
+		t.Errorf("Expected 3 raft server inventory items got %d", len(items))
+	}
+}
+
+func Test_Datacenter_CollectMetrics_AutopilotServers(t *testing.T) {
+	mux, hostname, port, serverClose := testutils.SetupServer()
+	defer serverClose()
+
+	arg := args.ArgumentList{
+		Hostname:  hostname,
+		Port:      port,
+		EnableSSL: false,
+		Timeout:   "0s",
+	}
+
+	apiConfig, err := arg.CreateAPIConfig(arg.Hostname)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	client, err := api.NewClient(apiConfig)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	i, err := integration.New("test", "1.0.0")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	dcEntity, err := i.Entity("test", "datacenter")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	agentEntity, err := i.Entity("leader", "agent")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	c := &Datacenter{
+		entity:      dcEntity,
+		leader:      agent.NewAgent(client, agentEntity, "leader", "10.0.0.1", "8301", "dc1", ""),
+		integration: i,
+		name:        "test",
+	}
+
+	setMetricMuxes(mux)
+
+	timeNow = func() time.Time {
+		return time.Date(2018, 10, 26, 14, 17, 50, 0, time.UTC)
+	}
+	defer func() { timeNow = time.Now }()
+
+	expected := []map[string]interface{}{
+		{
+			"event_type":                          "ConsulServerSample",
+			"displayName":                         c.entity.Metadata.Name,
+			"entityName":                          c.entity.Metadata.Namespace + ":" + c.entity.Metadata.Name,
+			"serverID":                            "fbfe7e9b-5d30-284b-cc05-d2d5cc43688d",
+			"serverName":                          "consul-dev-0",
+			"address":                             "10.0.0.140:8300",
+			"version":                             "1.2.1",
+			"serfStatus":                          "alive",
+			"leader":                              "true",
+			"voter":                               "true",
+			"autopilot.healthy":                   float64(1),
+			"autopilot.lastContactInMilliseconds": float64(0),
+			"autopilot.lastTerm":                  float64(3),
+			"autopilot.lastIndex":                 float64(120),
+			"autopilot.lastIndexLag":              float64(0),
+			"autopilot.stableSinceInSeconds":      float64(1070),
+		},
+		{
+			"event_type":                          "ConsulServerSample",
+			"displayName":                         c.entity.Metadata.Name,
+			"entityName":                          c.entity.Metadata.Namespace + ":" + c.entity.Metadata.Name,
+			"serverID":                            "c7f88fba-f8d9-94a9-3627-523398acf7db",
+			"serverName":                          "consul-dev-1",
+			"address":                             "10.0.0.142:8300",
+			"version":                             "1.2.1",
+			"serfStatus":                          "failed",
+			"leader":                              "false",
+			"voter":                               "true",
+			"autopilot.healthy":                   float64(0),
+			"autopilot.lastContactInMilliseconds": float64(1500),
+			"autopilot.lastTerm":                  float64(2),
+			"autopilot.lastIndex":                 float64(100),
+			"autopilot.lastIndexLag":              float64(20),
+			"autopilot.stableSinceInSeconds":      float64(50),
+		},
+	}
+
+	c.CollectMetrics(nil, BuiltInDefinitions())
+
+	result := make([]map[string]interface{}, 0, len(expected))
+	for _, metricSet := range c.entity.Metrics {
+		if metricSet.Metrics["event_type"] == "ConsulServerSample" {
+			result = append(result, metricSet.Metrics)
+		}
+	}
+
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %+v got %+v", expected, result)
+	}
+}
+
+func Test_Datacenter_RemoteDatacenters(t *testing.T) {
+	mux, hostname, port, serverClose := testutils.SetupServer()
+	defer serverClose()
+
+	arg := args.ArgumentList{
+		Hostname:  hostname,
+		Port:      port,
+		EnableSSL: false,
+		Timeout:   "0s",
+	}
+
+	apiConfig, err := arg.CreateAPIConfig(arg.Hostname)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	client, err := api.NewClient(apiConfig)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	i, err := integration.New("test", "1.0.0")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	dcEntity, err := i.Entity("dc1", "co-datacenter")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	agentEntity, err := i.Entity("leader", "agent")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	c := &Datacenter{
+		entity:      dcEntity,
+		leader:      agent.NewAgent(client, agentEntity, "leader", "10.0.0.1", "8301", "dc1", ""),
+		integration: i,
+		name:        "dc1",
+	}
+
+	mux.HandleFunc("/v1/catalog/datacenters", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `["dc1", "dc2"]`)
+	})
+
+	// only answer queries that target the remote datacenter
+	mux.HandleFunc("/v1/status/leader", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("dc") != "dc2" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `"10.1.0.1:8300"`)
+	})
+
+	mux.HandleFunc("/v1/catalog/nodes", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("dc") != "dc2" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `[
+			{
+				"ID": "1234",
+				"Node": "consul-dc2-0",
+				"Address": "10.1.0.1",
+				"Datacenter": "dc2"
+			}
+		]`)
+	})
+
+	mux.HandleFunc("/v1/agent/metrics", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Agent metrics should not be collected for a remote Datacenter")
+		w.WriteHeader(http.StatusBadRequest)
+	})
+
+	remotes, err := c.RemoteDatacenters()
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	if len(remotes) != 1 {
+		t.Fatalf("Expected 1 remote Datacenter got %d", len(remotes))
+	}
+
+	remote := remotes[0]
+	if remote.entity.Metadata.Name != "dc2" || remote.entity.Metadata.Namespace != "co-datacenter" {
+		t.Fatalf("Unexpected entity %+v", remote.entity.Metadata)
+	}
+
+	expected := map[string]interface{}{
+		"event_type":              "ConsulDatacenterSample",
+		"displayName":             "dc2",
+		"entityName":              "co-datacenter:dc2",
+		"leader":                  "10.1.0.1:8300",
+		"catalog.registeredNodes": float64(1),
+	}
+
+	remote.CollectMetrics(nil, BuiltInDefinitions())
+
+	result := remote.entity.Metrics[0].Metrics
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %+v got %+v", expected, result)
+	}
+}
+
+func Test_Datacenter_CollectMetrics_IPv6Leader(t *testing.T) {
+	mux, hostname, port, serverClose := testutils.SetupServer()
+	defer serverClose()
+
+	arg := args.ArgumentList{
+		Hostname:  hostname,
+		Port:      port,
+		EnableSSL: false,
+		Timeout:   "0s",
+	}
+
+	apiConfig, err := arg.CreateAPIConfig(arg.Hostname)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	client, err := api.NewClient(apiConfig)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	i, err := integration.New("test", "1.0.0")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	agentEntity, err := i.Entity("[fd00::1]:8301", "co-agent")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	mux.HandleFunc("/v1/agent/self", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"Config": {"Datacenter": "dc1"}}`)
+	})
+
+	mux.HandleFunc("/v1/catalog/datacenters", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `["dc1", "dc2"]`)
+	})
+
+	mux.HandleFunc("/v1/status/leader", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `"[fd00:1::1]:8300"`)
+	})
+
+	dc, err := NewDatacenter(agent.NewAgent(client, agentEntity, "consul-0", "fd00::1", "8301", "dc1", ""), i, &args.ArgumentList{})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	remotes, err := dc.RemoteDatacenters()
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	expected := map[string]string{
+		"dc1": "[fd00::1]:8301",
+		"dc2": "[fd00:1::1]:8300",
+	}
+
+	for _, d := range append([]*Datacenter{dc}, remotes...) {
+		d.CollectMetrics(nil, BuiltInDefinitions())
+
+		if len(d.entity.Metrics) == 0 {
+			t.Errorf("Expected metrics for Datacenter %s", d.name)
+			continue
+		}
+
+		if leader := d.entity.Metrics[0].Metrics["leader"]; leader != expected[d.name] {
+			t.Errorf("Expected leader %s for Datacenter %s got %v", expected[d.name], d.name, leader)
+		}
+	}
+}
+
+func Test_Datacenter_CollectMetrics_All_Endpoint_Fails(t *testing.T) {
+	_, hostname, port, serverClose := testutils.SetupServer()
+	defer serverClose()
+
+	arg := args.ArgumentList{
+		Hostname:  hostname,
+		Port:      port,
+		EnableSSL: false,
+		Timeout:   "0s",
+	}
+
+	apiConfig, err := arg.CreateAPIConfig(arg.Hostname)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	client, err := api.NewClient(apiConfig)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	i, err := integration.New("test", "1.0.0")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	dcEntity, err := i.Entity("test", "datacenter")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	agentEntity, err := i.Entity("leader", "agent")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	c := &Datacenter{
+		entity:      dcEntity,
+		leader:      agent.NewAgent(client, agentEntity, "leader", "10.0.0.1", "8301", "dc1", ""),
+		integration: i,
+		name:        "test",
+	}
+
+	expected := map[string]interface{}{
+		"event_type":  "ConsulDatacenterSample",
+		"displayName": c.entity.Metadata.Name,
+		"entityName":  c.entity.Metadata.Namespace + ":" + c.entity.Metadata.Name,
+		"leader":      "10.0.0.1:8301",
+	}
+
+	c.CollectMetrics(nil, BuiltInDefinitions())
+
+	result := c.entity.Metrics[0].Metrics
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %+v got %+v", expected, result)
+	}
+}
+
+func setMetricMuxes(mux *http.ServeMux) {
+	mux.HandleFunc("/v1/agent/members", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("wan") != "1" {
+			fmt.Fprint(w, `[
+				{
+					"Name": "consul-dev-0",
+					"Addr": "10.0.0.140",
+					"Port": 8301,
+					"Tags": {
+						"dc": "test",
+						"role": "consul"
+					},
+					"Status": 1
+				},
+				{
+					"Name": "consul-dev-1",
+					"Addr": "10.0.0.142",
+					"Port": 8301,
+					"Tags": {
+						"dc": "test",
+						"role": "consul"
+					},
+					"Status": 4
+				},
+				{
+					"Name": "consul-dev-2",
+					"Addr": "10.0.0.143",
+					"Port": 8301,
+					"Tags": {
+						"dc": "test",
+						"role": "consul"
+					},
+					"Status": 2
+				},
+				{
+					"Name": "vault-dev-0",
+					"Addr": "10.0.0.55",
+					"Port": 8301,
+					"Tags": {
+						"dc": "test",
+						"role": "node"
+					},
+					"Status": 1
+				},
+				{
+					"Name": "vault-dev-1",
+					"Addr": "10.0.0.56",
+					"Port": 8301,
+					"Tags": {
+						"dc": "test",
+						"role": "node"
+					},
+					"Status": 3
+				}
+			]`)
+			return
+		}
+		fmt.Fprint(w, `[
+			{
+				"Name": "consul-dev-0.test",
+				"Addr": "10.0.0.140",
+				"Port": 8302,
+				"Tags": {
+					"dc": "test",
+					"role": "consul"
+				},
+				"Status": 1
+			},
+			{
+				"Name": "consul-dev-1.test",
+				"Addr": "10.0.0.142",
+				"Port": 8302,
+				"Tags": {
+					"dc": "test",
+					"role": "consul"
+				},
+				"Status": 4
+			},
+			{
+				"Name": "consul-remote-0.dc2",
+				"Addr": "10.1.0.1",
+				"Port": 8302,
+				"Tags": {
+					"dc": "dc2",
+					"role": "consul"
+				},
+				"Status": 1
+			}
+		]`)
+	})
+
+	mux.HandleFunc("/v1/coordinate/datacenters", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[
+			{
+				"Datacenter": "test",
+				"AreaID": "WAN",
+				"Coordinates": [
+					{
+						"Node": "consul-dev-0.test",
+						"Coord": {
+							"Vec": [0, 0],
+							"Error": 0.1,
+							"Adjustment": 0,
+							"Height": 0.5
+						}
+					},
+					{
+						"Node": "consul-dev-1.test",
+						"Coord": {
+							"Vec": [0, 0],
+							"Error": 0.1,
+							"Adjustment": 0,
+							"Height": 0.25
+						}
+					}
+				]
+			},
+			{
+				"Datacenter": "dc2",
+				"AreaID": "WAN",
+				"Coordinates": [
+					{
+						"Node": "consul-remote-0.dc2",
+						"Coord": {
+							"Vec": [0, 0],
+							"Error": 0.1,
+							"Adjustment": 0,
+							"Height": 0.125
+						}
+					}
+				]
+			}
+		]`)
+	})
+
+	mux.HandleFunc("/v1/agent/metrics", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{
+			"Timestamp": "2018-10-26 14:17:50 +0000 UTC",
+			"Gauges": [],
+			"Points": [],
+			"Counters": [
+				{
+					"Name": "consul.raft.apply",
+					"Count": 2,
+					"Rate": 0.2,
+					"Sum": 2,
+					"Min": 1,
+					"Max": 1,
+					"Mean": 1,
+					"Stddev": 0,
+					"Labels": {}
+				}
+			],
+			"Samples": [
+				{
+					"Name": "consul.raft.commitTime",
+					"Count": 1,
+					"Rate": 0.5,
+					"Sum": 5,
+					"Min": 1,
+					"Max": 5,
+					"Mean": 3,
+					"Stddev": 0,
+					"Labels": {}
+				}
+			]
+		}`)
+	})
+
+	mux.HandleFunc("/v1/operator/raft/configuration", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{
+			"Servers": [
+				{
 					"ID": "fbfe7e9b-5d30-284b-cc05-d2d5cc43688d",
 					"Node": "consul-dev-0",
-					"Address": "10.0.0.140",
-					"Datacenter": "dev",
-					"TaggedAddresses": {
-						"lan": "10.0.0.140",
-						"wan": "10.0.0.140"
-					},
-					"Meta": {
-						"consul-network-segment": ""
-					},
-					"CreateIndex": 8,
-					"ModifyIndex": 9
+					"Address": "10.0.0.140:8300",
+					"Leader": true,
+					"ProtocolVersion": "3",
+					"Voter": true
 				},
-				"Service": {
-					"ID": "consul",
-					"Service": "consul",
-					"Tags": [],
-					"Address": "",
-					"Meta": null,
-					"Port": 8300,
-					"EnableTagOverride": false,
-					"ProxyDestination": "",
-					"Connect": {
-						"Native": false,
-						"Proxy": null
-					},
-					"CreateIndex": 8,
-					"ModifyIndex": 8
+				{
+					"ID": "c7f88fba-f8d9-94a9-3627-523398acf7db",
+					"Node": "consul-dev-1",
+					"Address": "10.0.0.142:8300",
+					"Leader": false,
+					"ProtocolVersion": "3",
+					"Voter": true
 				},
-				"Checks": [
-					{
-						"Node": "consul-dev-0",
-						"CheckID": "serfHealth",
-						"Name": "Serf Health Status",
-						"Status": "critical",
-						"Notes": "",
-						"Output": "Agent alive and reachable",
-						"ServiceID": "",
-						"ServiceName": "",
-						"ServiceTags": [],
-						"Definition": {},
-						"CreateIndex": 8,
-						"ModifyIndex": 8
-					}
-				]
+				{
+					"ID": "8b4b2a1c-0c65-4a4f-a4a4-2a0e0c2c8b51",
+					"Node": "consul-dev-2",
+					"Address": "10.0.0.143:8300",
+					"Leader": false,
+					"ProtocolVersion": "3",
+					"Voter": false
+				}
+			],
+			"Index": 42
+		}`)
+	})
+
+	mux.HandleFunc("/v1/operator/autopilot/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{
+			"Healthy": false,
+			"FailureTolerance": 0,
+			"Servers": [
+				{
+					"ID": "fbfe7e9b-5d30-284b-cc05-d2d5cc43688d",
+					"Name": "consul-dev-0",
+					"Address": "10.0.0.140:8300",
+					"SerfStatus": "alive",
+					"Version": "1.2.1",
+					"Leader": true,
+					"LastContact": "0s",
+					"LastTerm": 3,
+					"LastIndex": 120,
+					"Healthy": true,
+					"Voter": true,
+					"StableSince": "2018-10-26T14:00:00Z"
+				},
+				{
+					"ID": "c7f88fba-f8d9-94a9-3627-523398acf7db",
+					"Name": "consul-dev-1",
+					"Address": "10.0.0.142:8300",
+					"SerfStatus": "failed",
+					"Version": "1.2.1",
+					"Leader": false,
+					"LastContact": "1.5s",
+					"LastTerm": 2,
+					"LastIndex": 100,
+					"Healthy": false,
+					"Voter": true,
+					"StableSince": "2018-10-26T14:17:00Z"
+				}
+			]
+		}`)
+	})
+
+	mux.HandleFunc("/v1/catalog/nodes", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[
+			{
+				"ID": "1234",
+				"Node": "consul-dev-0",
+				"Address": "10.0.0.140",
+				"Datacenter": "dev",
+				"TaggedAddresses": {
+					"lan": "10.0.0.140",
+					"wan": "10.0.0.140"
+				},
+				"Meta": {
+					"consul-network-segment": ""
+				},
+				"CreateIndex": 8,
+				"ModifyIndex": 9
 			},
 			{
-				"Node": {
-					"ID": "c7f88fba-f8d9-94a9-3627-523398acf7db",
-					"Node": "consul-dev-1",
-					"Address": "10.0.0.142",
-					"Datacenter": "dev",
-					"TaggedAddresses": {
-						"lan": "10.0.0.142",
-						"wan": "10.0.0.142"
-					},
-					"Meta": {
-						"consul-network-segment": ""
-					},
-					"CreateIndex": 65,
-					"ModifyIndex": 67
+				"ID": "5678",
+				"Node": "consul-dev-1",
+				"Address": "10.0.0.142",
+				"Datacenter": "dev",
+				"TaggedAddresses": {
+					"lan": "10.0.0.142",
+					"wan": "10.0.0.142"
 				},
-				"Service": {
-					"ID": "consul",
-					"Service": "consul",
-					"Tags": [],
-					"Address": "",
-					"Meta": null,
-					"Port": 8300,
-					"EnableTagOverride": false,
-					"ProxyDestination": "",
-					"Connect": {
-						"Native": false,
-						"Proxy": null
-					},
-					"CreateIndex": 65,
-					"ModifyIndex": 65
+				"Meta": {
+					"consul-network-segment": ""
 				},
-				"Checks": [
-					{
-						"Node": "consul-dev-1",
-						"CheckID": "serfHealth",
-						"Name": "Serf Health Status",
-						"Status": "passing",
-						"Notes": "",
-						"Output": "Agent alive and reachable",
-						"ServiceID": "",
-						"ServiceName": "",
-						"ServiceTags": [],
-						"Definition": {},
-						"CreateIndex": 65,
-						"ModifyIndex": 65
-					}
-				]
+				"CreateIndex": 65,
+				"ModifyIndex": 67
+			},
+			{
+				"ID": "91011",
+				"Node": "vault-dev-1",
+				"Address": "10.0.0.55",
+				"Datacenter": "dev",
+				"TaggedAddresses": {
+					"lan": "10.0.0.55",
+					"wan": "10.0.0.55"
+				},
+				"Meta": {
+					"consul-network-segment": ""
+				},
+				"CreateIndex": 6,
+				"ModifyIndex": 7
+			}
+		]`)
+	})
+
+	mux.HandleFunc("/v1/catalog/services", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{
+			"consul": [],
+			"vault": [
+				"active"
+			],
+			"web": []
+		}`)
+	})
+
+	mux.HandleFunc("/v1/health/state/any", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[
+			{
+				"Node": "consul-dev-0",
+				"CheckID": "serfHealth",
+				"Name": "Serf Health Status",
+				"Status": "critical",
+				"Notes": "",
+				"Output": "Agent alive and reachable",
+				"ServiceID": "",
+				"ServiceName": "",
+				"ServiceTags": [],
+				"Definition": {},
+				"CreateIndex": 8,
+				"ModifyIndex": 8
+			},
+			{
+				"Node": "consul-dev-1",
+				"CheckID": "serfHealth",
+				"Name": "Serf Health Status",
+				"Status": "passing",
+				"Notes": "",
+				"Output": "Agent alive and reachable",
+				"ServiceID": "",
+				"ServiceName": "",
+				"ServiceTags": [],
+				"Definition": {},
+				"CreateIndex": 65,
+				"ModifyIndex": 65
+			},
+			{
+				"Node": "vault-dev-0",
+				"CheckID": "serfHealth",
+				"Name": "Serf Health Status",
+				"Status": "warning",
+				"Notes": "",
+				"Output": "Agent alive and reachable",
+				"ServiceID": "",
+				"ServiceName": "",
+				"ServiceTags": [],
+				"Definition": {},
+				"CreateIndex": 20183,
+				"ModifyIndex": 20183
+			},
+			{
+				"Node": "vault-dev-0",
+				"CheckID": "vault:vault-dev-0.consul.localnet:8200:vault-sealed-check",
+				"Name": "Vault Sealed Status",
+				"Status": "passing",
+				"Notes": "Vault service is healthy when Vault is in an unsealed status and can become an active Vault server",
+				"Output": "Vault Unsealed",
+				"ServiceID": "vault:vault-dev-0.consul.localnet:8200",
+				"ServiceName": "vault",
+				"ServiceTags": [
+					"active"
+				],
+				"Definition": {},
+				"CreateIndex": 20260,
+				"ModifyIndex": 20329
+			},
+			{
+				"Node": "vault-dev-0",
+				"CheckID": "service:web-0",
+				"Name": "Service 'web' check",
+				"Status": "passing",
+				"Notes": "",
+				"Output": "",
+				"ServiceID": "web-0",
+				"ServiceName": "web",
+				"ServiceTags": [],
+				"Definition": {},
+				"CreateIndex": 20400,
+				"ModifyIndex": 20400
+			},
+			{
+				"Node": "consul-dev-1",
+				"CheckID": "service:web-1",
+				"Name": "Service 'web' check",
+				"Status": "passing",
+				"Notes": "",
+				"Output": "",
+				"ServiceID": "web-1",
+				"ServiceName": "web",
+				"ServiceTags": [],
+				"Definition": {},
+				"CreateIndex": 20401,
+				"ModifyIndex": 20401
+			}
+		]`)
+	})
+
+	mux.HandleFunc("/v1/catalog/service/vault", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[
+			{
+				"ID": "91011",
+				"Node": "vault-dev-0",
+				"Address": "10.0.0.55",
+				"Datacenter": "dev",
+				"ServiceID": "vault:vault-dev-0.consul.localnet:8200",
+				"ServiceName": "vault",
+				"ServiceTags": [
+					"active"
+				],
+				"ServiceAddress": "vault-dev-0.consul.localnet",
+				"ServicePort": 8200
+			}
+		]`)
+	})
+
+	mux.HandleFunc("/v1/catalog/service/consul", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[
+			{
+				"ID": "fbfe7e9b-5d30-284b-cc05-d2d5cc43688d",
+				"Node": "consul-dev-0",
+				"Address": "10.0.0.140",
+				"Datacenter": "dev",
+				"ServiceID": "consul",
+				"ServiceName": "consul",
+				"ServiceTags": [],
+				"ServicePort": 8300
+			},
+			{
+				"ID": "c7f88fba-f8d9-94a9-3627-523398acf7db",
+				"Node": "consul-dev-1",
+				"Address": "10.0.0.142",
+				"Datacenter": "dev",
+				"ServiceID": "consul",
+				"ServiceName": "consul",
+				"ServiceTags": [],
+				"ServicePort": 8300
+			}
+		]`)
+	})
+
+	mux.HandleFunc("/v1/catalog/service/web", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[
+			{
+				"Node": "vault-dev-0",
+				"Address": "10.0.0.55",
+				"Datacenter": "dev",
+				"ServiceID": "web-0",
+				"ServiceName": "web",
+				"ServicePort": 80
+			},
+			{
+				"Node": "consul-dev-1",
+				"Address": "10.0.0.142",
+				"Datacenter": "dev",
+				"ServiceID": "web-1",
+				"ServiceName": "web",
+				"ServicePort": 80
 			}
 		]`)
 	})
 }
+
+func Test_Datacenter_collectWANLatencyMetrics_SingleDatacenter(t *testing.T) {
+	mux, hostname, port, serverClose := testutils.SetupServer()
+	defer serverClose()
+
+	arg := args.ArgumentList{
+		Hostname:  hostname,
+		Port:      port,
+		EnableSSL: false,
+		Timeout:   "0s",
+	}
+
+	apiConfig, err := arg.CreateAPIConfig(arg.Hostname)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	client, err := api.NewClient(apiConfig)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	i, err := integration.New("test", "1.0.0")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	dcEntity, err := i.Entity("dc1", "datacenter")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	agentEntity, err := i.Entity("leader", "agent")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	c := &Datacenter{
+		entity:      dcEntity,
+		leader:      agent.NewAgent(client, agentEntity, "leader", "10.0.0.1", "8301", "dc1", ""),
+		integration: i,
+		name:        "dc1",
+	}
+
+	mux.HandleFunc("/v1/coordinate/datacenters", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[
+			{"Datacenter": "dc1", "AreaID": "wan", "Coordinates": [
+				{"Node": "consul-0.dc1", "Coord": {"Vec": [0, 0], "Error": 0, "Adjustment": 0, "Height": 0}}
+			]}
+		]`)
+	})
+
+	metricSet := dcEntity.NewMetricSet("ConsulDatacenterSample")
+	// without other datacenters there's nothing to report, which isn't an error
+	if err := c.collectWANLatencyMetrics(metricSet); err != nil {
+		t.Errorf("Unexpected error %s", err.Error())
+	}
+
+	if _, ok := metricSet.Metrics["net.wan.minLatencyInMilliseconds"]; ok {
+		t.Error("Expected no WAN latency metrics")
+	}
+}
+
+func Test_Datacenter_setNodeCountMetric_FilteredByACLs(t *testing.T) {
+	mux, hostname, port, serverClose := testutils.SetupServer()
+	defer serverClose()
+
+	arg := args.ArgumentList{
+		Hostname:  hostname,
+		Port:      port,
+		EnableSSL: false,
+		Timeout:   "0s",
+	}
+
+	apiConfig, err := arg.CreateAPIConfig(arg.Hostname)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	client, err := api.NewClient(apiConfig)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	i, err := integration.New("test", "1.0.0")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	dcEntity, err := i.Entity("dc1", "datacenter")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	agentEntity, err := i.Entity("leader", "agent")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	c := &Datacenter{
+		entity:      dcEntity,
+		leader:      agent.NewAgent(client, agentEntity, "leader", "10.0.0.1", "8301", "dc1", ""),
+		integration: i,
+		name:        "dc1",
+	}
+
+	// the token can only read one of the nodes
+	mux.HandleFunc("/v1/catalog/nodes", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Consul-LastContact", "0")
+		w.Header().Set("X-Consul-KnownLeader", "true")
+		w.Header().Set("X-Consul-Results-Filtered-By-ACLs", "true")
+		fmt.Fprint(w, `[{"Node": "consul-0"}]`)
+	})
+
+	perms := permissions.New(client)
+	metricSet := dcEntity.NewMetricSet("ConsulDatacenterSample")
+	if _, err := c.setNodeCountMetric(metricSet, perms); err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	inventoryEntity := i.LocalEntity()
+	perms.SetInventory(inventoryEntity, "")
+
+	item, ok := inventoryEntity.Inventory.Item(permissions.InventoryKey)
+	if !ok {
+		t.Fatalf("Expected the %s inventory item", permissions.InventoryKey)
+	}
+
+	if _, ok := item[permissions.DatacenterCatalog]; !ok {
+		t.Errorf("Expected %s to report partial data, got %+v", permissions.DatacenterCatalog, item)
+	}
+}
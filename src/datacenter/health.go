@@ -0,0 +1,194 @@
+package datacenter
+
+import (
+	"sync"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/newrelic/infra-integrations-sdk/v3/log"
+	"github.com/newrelic/nri-consul/src/permissions"
+)
+
+// instanceKey identifies a single service instance registered on a node
+type instanceKey struct {
+	node      string
+	serviceID string
+}
+
+// healthState holds every health check in the Datacenter grouped by node and service instance
+type healthState struct {
+	// nodeChecks are the node level checks (those without a service) for each node
+	nodeChecks map[string]api.HealthChecks
+	// serviceChecks are the service level checks for each instance of each service
+	serviceChecks map[string]map[instanceKey]api.HealthChecks
+}
+
+func newHealthState() *healthState {
+	return &healthState{
+		nodeChecks:    make(map[string]api.HealthChecks),
+		serviceChecks: make(map[string]map[instanceKey]api.HealthChecks),
+	}
+}
+
+// addCheck files a check under its node or under its service instance
+func (hs *healthState) addCheck(check *api.HealthCheck) {
+	if check.ServiceID == "" {
+		hs.nodeChecks[check.Node] = append(hs.nodeChecks[check.Node], check)
+		return
+	}
+
+	instances, ok := hs.serviceChecks[check.ServiceName]
+	if !ok {
+		instances = make(map[instanceKey]api.HealthChecks)
+		hs.serviceChecks[check.ServiceName] = instances
+	}
+
+	key := instanceKey{node: check.Node, serviceID: check.ServiceID}
+	instances[key] = append(instances[key], check)
+}
+
+// addInstances adds the catalog instances of a service, keeping the checks already filed under them.
+// Instances without service level checks only show up in the catalog.
+func (hs *healthState) addInstances(service string, entries []*api.CatalogService) {
+	instances, ok := hs.serviceChecks[service]
+	if !ok {
+		instances = make(map[instanceKey]api.HealthChecks, len(entries))
+		hs.serviceChecks[service] = instances
+	}
+
+	for _, entry := range entries {
+		key := instanceKey{node: entry.Node, serviceID: entry.ServiceID}
+		if _, ok := instances[key]; !ok {
+			instances[key] = api.HealthChecks{}
+		}
+	}
+}
+
+// instanceStatus is the aggregated status of a service instance, taking the checks of its node into account
+func (hs *healthState) instanceStatus(key instanceKey, checks api.HealthChecks) string {
+	all := make(api.HealthChecks, 0, len(checks)+len(hs.nodeChecks[key.node]))
+	all = append(all, hs.nodeChecks[key.node]...)
+	all = append(all, checks...)
+
+	return countedStatus(all.AggregatedStatus())
+}
+
+// nodeStatuses returns the worst status across the node and service checks of every node
+// that has at least one check. Nodes without any checks are not included.
+func (hs *healthState) nodeStatuses() map[string]string {
+	statuses := make(map[string]string, len(hs.nodeChecks))
+	for node, checks := range hs.nodeChecks {
+		for _, check := range checks {
+			statuses[node] = worstStatus(statuses[node], check.Status)
+		}
+	}
+
+	for _, instances := range hs.serviceChecks {
+		for key, checks := range instances {
+			for _, check := range checks {
+				statuses[key.node] = worstStatus(statuses[key.node], check.Status)
+			}
+		}
+	}
+
+	return statuses
+}
+
+// statusSeverity ranks check statuses from best to worst
+var statusSeverity = map[string]int{
+	api.HealthPassing:  1,
+	api.HealthWarning:  2,
+	api.HealthCritical: 3,
+}
+
+// countedStatus maps maintenance to critical, as Consul treats instances in maintenance as failing
+func countedStatus(status string) string {
+	if status == api.HealthMaint {
+		return api.HealthCritical
+	}
+
+	return status
+}
+
+// worstStatus returns the more severe of the two check statuses
+func worstStatus(current, status string) string {
+	status = countedStatus(status)
+	if statusSeverity[status] > statusSeverity[current] {
+		return status
+	}
+
+	return current
+}
+
+// serviceListingConcurrency bounds the catalog service queries in flight
+const serviceListingConcurrency = 8
+
+// getHealthState retrieves every health check in the Datacenter with a single call. Instances
+// without service level checks don't show up in the checks, so when listServiceInstances is set
+// the instances of every service are listed with a bounded number of concurrent stale catalog
+// queries, one per service. A failing listing only leaves that service with the instances found
+// in the checks.
+func (dc *Datacenter) getHealthState(perms *permissions.Set) (*healthState, error) {
+	checks, meta, err := dc.leader.Client.Health().State(api.HealthAny, dc.queryOptions)
+	if err != nil {
+		return nil, err
+	}
+	perms.Filtered(permissions.DatacenterHealth, meta)
+
+	state := newHealthState()
+	for _, check := range checks {
+		state.addCheck(check)
+	}
+
+	if !dc.listServiceInstances {
+		return state, nil
+	}
+
+	services, meta, err := dc.leader.Client.Catalog().Services(dc.staleQueryOptions())
+	if err != nil {
+		log.Error("Error listing catalog services, only services with checks will be reported: %s", err.Error())
+		return state, nil
+	}
+	perms.Filtered(permissions.DatacenterHealth, meta)
+
+	for service, entries := range dc.listInstances(services, perms) {
+		state.addInstances(service, entries)
+	}
+
+	return state, nil
+}
+
+// listInstances lists the catalog instances of each service, leaving out the services whose listing failed
+func (dc *Datacenter) listInstances(services map[string][]string, perms *permissions.Set) map[string][]*api.CatalogService {
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		instances = make(map[string][]*api.CatalogService, len(services))
+		slots     = make(chan struct{}, serviceListingConcurrency)
+		options   = dc.staleQueryOptions()
+	)
+
+	for service := range services {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(service string) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			entries, meta, err := dc.leader.Client.Catalog().Service(service, "", options)
+			if err != nil {
+				log.Error("Error listing instances of service '%s': %s", service, err.Error())
+				return
+			}
+			perms.Filtered(permissions.DatacenterHealth, meta)
+
+			mu.Lock()
+			instances[service] = entries
+			mu.Unlock()
+		}(service)
+	}
+	wg.Wait()
+
+	return instances
+}
@@ -0,0 +1,65 @@
+package datacenter
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/newrelic/infra-integrations-sdk/v3/data/metric"
+	"github.com/newrelic/nri-consul/src/agent"
+	"github.com/newrelic/nri-consul/src/metrics"
+)
+
+// memberRoles maps the member role tag to the role reported in the metric names
+var memberRoles = map[string]string{
+	api.MemberTagValueRoleServer: "server",
+	api.MemberTagValueRoleClient: "client",
+}
+
+// newMemberStatusCounts creates a zeroed count for every reported serf status
+func newMemberStatusCounts() map[string]int {
+	return map[string]int{
+		agent.MemberStatusAlive:   0,
+		agent.MemberStatusLeaving: 0,
+		agent.MemberStatusLeft:    0,
+		agent.MemberStatusFailed:  0,
+	}
+}
+
+// collectMemberCounts counts the LAN pool members by serf status, in total and split by role.
+// The LAN pool is only visible to the local Datacenter.
+func (dc *Datacenter) collectMemberCounts(metricSet *metric.Set) error {
+	members, err := dc.leader.Client.Agent().Members(false)
+	if err != nil {
+		return err
+	}
+
+	totalCounts := newMemberStatusCounts()
+	roleCounts := make(map[string]map[string]int, len(memberRoles))
+	for _, role := range memberRoles {
+		roleCounts[role] = newMemberStatusCounts()
+	}
+
+	for _, member := range members {
+		status := agent.MemberStatus(member)
+		if _, ok := totalCounts[status]; !ok {
+			continue
+		}
+
+		totalCounts[status]++
+		if role, ok := memberRoles[member.Tags[api.MemberTagKeyRole]]; ok {
+			roleCounts[role][status]++
+		}
+	}
+
+	for status, count := range totalCounts {
+		metrics.SetMetric(metricSet, fmt.Sprintf("members.%s", status), count, metric.GAUGE)
+	}
+
+	for role, counts := range roleCounts {
+		for status, count := range counts {
+			metrics.SetMetric(metricSet, fmt.Sprintf("members.%s.%s", role, status), count, metric.GAUGE)
+		}
+	}
+
+	return nil
+}
@@ -5,31 +5,36 @@ import (
 	"github.com/newrelic/nri-consul/src/metrics"
 )
 
+// gaugeMetrics of the leader are only collected when the metric definitions file adds some
+var gaugeMetrics []*metrics.MetricDefinition
+
+// counterMetrics are reported per second. Counters reset every telemetry interval so
+// they are gauges, rating them would take the delta of unrelated intervals.
 var counterMetrics = []*metrics.MetricDefinition{
 	{
 		APIKey:     "consul.memberlist.msg.suspect",
 		MetricName: "cluster.suspects",
-		SourceType: metric.RATE,
+		SourceType: metric.GAUGE,
 	},
 	{
 		APIKey:     "consul.serf.member.flap",
 		MetricName: "cluster.flaps",
-		SourceType: metric.RATE,
+		SourceType: metric.GAUGE,
 	},
 	{
 		APIKey:     "consul.raft.state.leader",
 		MetricName: "raft.completedLeaderElections",
-		SourceType: metric.RATE,
+		SourceType: metric.GAUGE,
 	},
 	{
 		APIKey:     "consul.raft.state.candidate",
 		MetricName: "raft.initiatedLeaderElections",
-		SourceType: metric.RATE,
+		SourceType: metric.GAUGE,
 	},
 	{
 		APIKey:     "consul.raft.apply",
 		MetricName: "raft.txns",
-		SourceType: metric.RATE,
+		SourceType: metric.GAUGE,
 	},
 }
 
@@ -46,9 +51,9 @@ var timerMetrics = []*metrics.TimerDefinition{
 		MetricDefinition: metrics.MetricDefinition{
 			APIKey:     "consul.raft.commitTime",
 			MetricName: "raft.commitTimes",
-			SourceType: metric.RATE,
+			SourceType: metric.GAUGE,
 		},
-		Operation: metrics.Count,
+		Operation: metrics.CountPerSecond,
 	},
 	{
 		MetricDefinition: metrics.MetricDefinition{
@@ -58,6 +63,38 @@ var timerMetrics = []*metrics.TimerDefinition{
 		},
 		Operation: metrics.Max,
 	},
+	{
+		MetricDefinition: metrics.MetricDefinition{
+			APIKey:     "consul.raft.commitTime",
+			MetricName: "raft.commitTimeMinInMilliseconds",
+			SourceType: metric.GAUGE,
+		},
+		Operation: metrics.Min,
+	},
+	{
+		MetricDefinition: metrics.MetricDefinition{
+			APIKey:     "consul.raft.commitTime",
+			MetricName: "raft.commitTimeStddevInMilliseconds",
+			SourceType: metric.GAUGE,
+		},
+		Operation: metrics.Stddev,
+	},
+	{
+		MetricDefinition: metrics.MetricDefinition{
+			APIKey:     "consul.raft.commitTime",
+			MetricName: "raft.commitTimeSumInMilliseconds",
+			SourceType: metric.GAUGE,
+		},
+		Operation: metrics.Sum,
+	},
+	{
+		MetricDefinition: metrics.MetricDefinition{
+			APIKey:     "consul.raft.commitTime",
+			MetricName: "raft.commitTimeInMillisecondsPerSecond",
+			SourceType: metric.GAUGE,
+		},
+		Operation: metrics.Rate,
+	},
 	{
 		MetricDefinition: metrics.MetricDefinition{
 			APIKey:     "consul.raft.leader.dispatchLog",
@@ -70,9 +107,9 @@ var timerMetrics = []*metrics.TimerDefinition{
 		MetricDefinition: metrics.MetricDefinition{
 			APIKey:     "consul.raft.leader.dispatchLog",
 			MetricName: "raft.logDispatches",
-			SourceType: metric.RATE,
+			SourceType: metric.GAUGE,
 		},
-		Operation: metrics.Count,
+		Operation: metrics.CountPerSecond,
 	},
 	{
 		MetricDefinition: metrics.MetricDefinition{
@@ -82,6 +119,38 @@ var timerMetrics = []*metrics.TimerDefinition{
 		},
 		Operation: metrics.Max,
 	},
+	{
+		MetricDefinition: metrics.MetricDefinition{
+			APIKey:     "consul.raft.leader.dispatchLog",
+			MetricName: "raft.logDispatchMinInMilliseconds",
+			SourceType: metric.GAUGE,
+		},
+		Operation: metrics.Min,
+	},
+	{
+		MetricDefinition: metrics.MetricDefinition{
+			APIKey:     "consul.raft.leader.dispatchLog",
+			MetricName: "raft.logDispatchStddevInMilliseconds",
+			SourceType: metric.GAUGE,
+		},
+		Operation: metrics.Stddev,
+	},
+	{
+		MetricDefinition: metrics.MetricDefinition{
+			APIKey:     "consul.raft.leader.dispatchLog",
+			MetricName: "raft.logDispatchSumInMilliseconds",
+			SourceType: metric.GAUGE,
+		},
+		Operation: metrics.Sum,
+	},
+	{
+		MetricDefinition: metrics.MetricDefinition{
+			APIKey:     "consul.raft.leader.dispatchLog",
+			MetricName: "raft.logDispatchInMillisecondsPerSecond",
+			SourceType: metric.GAUGE,
+		},
+		Operation: metrics.Rate,
+	},
 	{
 		MetricDefinition: metrics.MetricDefinition{
 			APIKey:     "consul.raft.leader.lastContact",
@@ -94,9 +163,9 @@ var timerMetrics = []*metrics.TimerDefinition{
 		MetricDefinition: metrics.MetricDefinition{
 			APIKey:     "consul.raft.leader.lastContact",
 			MetricName: "raft.lastContacts",
-			SourceType: metric.RATE,
+			SourceType: metric.GAUGE,
 		},
-		Operation: metrics.Count,
+		Operation: metrics.CountPerSecond,
 	},
 	{
 		MetricDefinition: metrics.MetricDefinition{
@@ -106,4 +175,77 @@ var timerMetrics = []*metrics.TimerDefinition{
 		},
 		Operation: metrics.Max,
 	},
+	{
+		MetricDefinition: metrics.MetricDefinition{
+			APIKey:     "consul.raft.leader.lastContact",
+			MetricName: "raft.lastContactMinInMilliseconds",
+			SourceType: metric.GAUGE,
+		},
+		Operation: metrics.Min,
+	},
+	{
+		MetricDefinition: metrics.MetricDefinition{
+			APIKey:     "consul.raft.leader.lastContact",
+			MetricName: "raft.lastContactStddevInMilliseconds",
+			SourceType: metric.GAUGE,
+		},
+		Operation: metrics.Stddev,
+	},
+	{
+		MetricDefinition: metrics.MetricDefinition{
+			APIKey:     "consul.raft.leader.lastContact",
+			MetricName: "raft.lastContactSumInMilliseconds",
+			SourceType: metric.GAUGE,
+		},
+		Operation: metrics.Sum,
+	},
+	{
+		MetricDefinition: metrics.MetricDefinition{
+			APIKey:     "consul.raft.leader.lastContact",
+			MetricName: "raft.lastContactInMillisecondsPerSecond",
+			SourceType: metric.GAUGE,
+		},
+		Operation: metrics.Rate,
+	},
+}
+
+// BuiltInDefinitions returns the metric definitions collected into ConsulDatacenterSample by default
+func BuiltInDefinitions() *metrics.Definitions {
+	return &metrics.Definitions{Gauges: gaugeMetrics, Counters: counterMetrics, Timers: timerMetrics}
+}
+
+// ReservedNames returns the attribute and metric names ConsulDatacenterSample reports besides its metric definitions
+func ReservedNames() []string {
+	names := []string{
+		"event_type", "displayName", "entityName", "leader", "clusterName",
+		"catalog.registeredNodes",
+		"catalog.criticalNodes",
+		"catalog.upNodes",
+		"catalog.warningNodes",
+		"catalog.passingNodes",
+		"catalog.uncheckedNodes",
+		"catalog.criticalServiceInstances",
+		"catalog.warningServiceInstances",
+		"catalog.passingServiceInstances",
+		"raft.servers",
+		"raft.voters",
+		"raft.nonVoters",
+		"raft.protocolVersions",
+		"raft.serversByProtocolVersion",
+		"protocolVersion",
+		"autopilot.healthy",
+		"autopilot.failureTolerance",
+		"net.wan.minLatencyInMilliseconds",
+		"net.wan.medianLatencyInMilliseconds",
+		"net.wan.p99LatencyInMilliseconds",
+	}
+
+	for status := range newMemberStatusCounts() {
+		names = append(names, "members."+status, "wan.members."+status)
+		for _, role := range memberRoles {
+			names = append(names, "members."+role+"."+status)
+		}
+	}
+
+	return names
 }
@@ -0,0 +1,141 @@
+package datacenter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/newrelic/infra-integrations-sdk/v3/data/attribute"
+	"github.com/newrelic/infra-integrations-sdk/v3/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/v3/log"
+	"github.com/newrelic/nri-consul/src/metrics"
+)
+
+// getRaftConfiguration retrieves the current raft peer set from the leader, once per Datacenter
+// as the metrics and the inventory are collected from the same peer set
+func (dc *Datacenter) getRaftConfiguration() (*api.RaftConfiguration, error) {
+	dc.raftOnce.Do(func() {
+		dc.raftConfig, dc.raftErr = dc.leader.Client.Operator().RaftGetConfiguration(dc.queryOptions)
+	})
+
+	return dc.raftConfig, dc.raftErr
+}
+
+// DefaultClusterName derives the name of the cluster from the raft peer set of the client's
+// datacenter, for runs without CLUSTER_NAME. It's derived once from the local datacenter so
+// remote datacenters are keyed by the same name.
+func DefaultClusterName(client *api.Client) (string, error) {
+	config, err := client.Operator().RaftGetConfiguration(&api.QueryOptions{AllowStale: true})
+	if err != nil {
+		return "", err
+	}
+
+	clusterName := defaultClusterName(config)
+	if clusterName == "" {
+		return "", errors.New("raft configuration has no servers")
+	}
+
+	return clusterName, nil
+}
+
+// defaultClusterName derives a cluster name from the IDs of the servers in the raft peer set.
+// It changes when a server is replaced, so setting CLUSTER_NAME keeps the entities stable.
+func defaultClusterName(config *api.RaftConfiguration) string {
+	if len(config.Servers) == 0 {
+		return ""
+	}
+
+	ids := make([]string, 0, len(config.Servers))
+	for _, server := range config.Servers {
+		ids = append(ids, server.ID)
+	}
+	sort.Strings(ids)
+
+	sum := sha256.Sum256([]byte(strings.Join(ids, ",")))
+	return "consul-" + hex.EncodeToString(sum[:6])
+}
+
+// collectRaftMetrics counts voters and non-voters, and the servers on each raft protocol version
+// in a metric set per version
+func (dc *Datacenter) collectRaftMetrics(metricSet *metric.Set) error {
+	config, err := dc.getRaftConfiguration()
+	if err != nil {
+		return err
+	}
+
+	voters, nonVoters := 0, 0
+	for _, server := range config.Servers {
+		if server.Voter {
+			voters++
+		} else {
+			nonVoters++
+		}
+	}
+
+	metrics.SetMetric(metricSet, "raft.servers", len(config.Servers), metric.GAUGE)
+	metrics.SetMetric(metricSet, "raft.voters", voters, metric.GAUGE)
+	metrics.SetMetric(metricSet, "raft.nonVoters", nonVoters, metric.GAUGE)
+	versions, counts := serversByProtocolVersion(config)
+	if len(versions) > 0 {
+		metrics.SetMetric(metricSet, "raft.protocolVersions", strings.Join(versions, ","), metric.ATTRIBUTE)
+	}
+
+	for _, version := range versions {
+		versionSet := dc.newMetricSet(attribute.Attribute{Key: "protocolVersion", Value: version})
+		metrics.SetMetric(versionSet, "raft.serversByProtocolVersion", counts[version], metric.GAUGE)
+	}
+
+	return nil
+}
+
+// serversByProtocolVersion counts the servers on each raft protocol version, returning the sorted versions.
+// More than one version means an upgrade is in progress.
+func serversByProtocolVersion(config *api.RaftConfiguration) ([]string, map[string]int) {
+	counts := make(map[string]int)
+	var versions []string
+	for _, server := range config.Servers {
+		if server.ProtocolVersion == "" {
+			continue
+		}
+
+		if counts[server.ProtocolVersion] == 0 {
+			versions = append(versions, server.ProtocolVersion)
+		}
+		counts[server.ProtocolVersion]++
+	}
+	sort.Strings(versions)
+
+	return versions, counts
+}
+
+// collectRaftInventory adds an inventory item for every server in the raft peer set
+func (dc *Datacenter) collectRaftInventory() error {
+	config, err := dc.getRaftConfiguration()
+	if err != nil {
+		return err
+	}
+
+	for _, server := range config.Servers {
+		key := "raft/servers/" + server.ID
+		dc.setInventoryItem(key, "id", server.ID)
+		dc.setInventoryItem(key, "node", server.Node)
+		dc.setInventoryItem(key, "address", server.Address)
+		dc.setInventoryItem(key, "leader", server.Leader)
+		dc.setInventoryItem(key, "voter", server.Voter)
+		if server.ProtocolVersion != "" {
+			dc.setInventoryItem(key, "protocolVersion", server.ProtocolVersion)
+		}
+	}
+
+	return nil
+}
+
+// setInventoryItem adds a wrapper around setting an inventory item
+func (dc *Datacenter) setInventoryItem(key, field string, value interface{}) {
+	if err := dc.entity.SetInventoryItem(key, field, value); err != nil {
+		log.Debug("Error setting Inventory item '%s' on Datacenter '%s': %s", key, dc.entity.Metadata.Name, err.Error())
+	}
+}
@@ -0,0 +1,75 @@
+package datacenter
+
+import (
+	"github.com/hashicorp/consul/api"
+	"github.com/newrelic/infra-integrations-sdk/v3/data/attribute"
+	"github.com/newrelic/infra-integrations-sdk/v3/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/v3/integration"
+	"github.com/newrelic/nri-consul/src/metrics"
+)
+
+// serviceHealth is the health rollup of every instance of a catalog service
+type serviceHealth struct {
+	name      string
+	instances int
+	passing   int
+	warning   int
+	critical  int
+	nodes     map[string]struct{}
+}
+
+func newServiceHealth(name string) *serviceHealth {
+	return &serviceHealth{
+		name:  name,
+		nodes: make(map[string]struct{}),
+	}
+}
+
+// addInstance records a service instance running on node with the given aggregated status
+func (sh *serviceHealth) addInstance(node, status string) {
+	sh.instances++
+	sh.nodes[node] = struct{}{}
+
+	switch status {
+	case api.HealthCritical:
+		sh.critical++
+	case api.HealthWarning:
+		sh.warning++
+	case api.HealthPassing:
+		sh.passing++
+	}
+}
+
+// healthyFraction is the fraction of instances that are passing all of their checks
+func (sh *serviceHealth) healthyFraction() float64 {
+	if sh.instances == 0 {
+		return 0
+	}
+
+	return float64(sh.passing) / float64(sh.instances)
+}
+
+// collectServiceMetrics creates the co-service entity for a service and reports its health rollup
+func (dc *Datacenter) collectServiceMetrics(sh *serviceHealth) error {
+	dcIDAttr := integration.NewIDAttribute("co-datacenter", dc.name)
+	entity, err := dc.integration.Entity(sh.name, "co-service", append([]integration.IDAttribute{dcIDAttr}, dc.idAttributes...)...)
+	if err != nil {
+		return err
+	}
+
+	metricSet := entity.NewMetricSet("ConsulServiceSample",
+		attribute.Attribute{Key: "displayName", Value: entity.Metadata.Name},
+		attribute.Attribute{Key: "entityName", Value: entity.Metadata.Namespace + ":" + entity.Metadata.Name},
+		attribute.Attribute{Key: "serviceName", Value: sh.name},
+		attribute.Attribute{Key: "datacenter", Value: dc.name},
+	)
+
+	metrics.SetMetric(metricSet, "service.instances", sh.instances, metric.GAUGE)
+	metrics.SetMetric(metricSet, "service.passingInstances", sh.passing, metric.GAUGE)
+	metrics.SetMetric(metricSet, "service.warningInstances", sh.warning, metric.GAUGE)
+	metrics.SetMetric(metricSet, "service.criticalInstances", sh.critical, metric.GAUGE)
+	metrics.SetMetric(metricSet, "service.healthyFraction", sh.healthyFraction(), metric.GAUGE)
+	metrics.SetMetric(metricSet, "service.nodes", len(sh.nodes), metric.GAUGE)
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package datacenter
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/newrelic/infra-integrations-sdk/v3/data/metric"
+	"github.com/newrelic/infra-integrations-sdk/v3/log"
+	"github.com/newrelic/nri-consul/src/agent"
+	"github.com/newrelic/nri-consul/src/metrics"
+)
+
+// collectWANMemberCounts counts the WAN pool members of the Datacenter by serf status
+func (dc *Datacenter) collectWANMemberCounts(metricSet *metric.Set) error {
+	members, err := dc.leader.Client.Agent().Members(true)
+	if err != nil {
+		return err
+	}
+
+	statusCounts := newMemberStatusCounts()
+
+	for _, member := range members {
+		if member.Tags[api.MemberTagKeyDatacenter] != dc.name {
+			continue
+		}
+
+		if _, ok := statusCounts[agent.MemberStatus(member)]; ok {
+			statusCounts[agent.MemberStatus(member)]++
+		}
+	}
+
+	for status, count := range statusCounts {
+		metrics.SetMetric(metricSet, fmt.Sprintf("wan.members.%s", status), count, metric.GAUGE)
+	}
+
+	return nil
+}
+
+// collectWANLatencyMetrics calculates the round trip times between the servers of the Datacenter
+// and the servers of every other datacenter in the same network area
+func (dc *Datacenter) collectWANLatencyMetrics(metricSet *metric.Set) error {
+	dcMaps, err := dc.leader.Client.Coordinate().Datacenters()
+	if err != nil {
+		return err
+	}
+
+	latencies := make([]float64, 0)
+	for _, local := range dcMaps {
+		if local.Datacenter != dc.name {
+			continue
+		}
+
+		for _, other := range dcMaps {
+			// coordinates are only comparable within the same area
+			if other.Datacenter == dc.name || other.AreaID != local.AreaID {
+				continue
+			}
+
+			latencies = append(latencies, serverLatencies(local.Coordinates, other.Coordinates)...)
+		}
+	}
+
+	// a single datacenter, the common deployment, has no latency to report
+	if len(latencies) == 0 {
+		log.Debug("No WAN coordinates found between Datacenter '%s' and other datacenters", dc.name)
+		return nil
+	}
+
+	sort.Float64s(latencies)
+
+	metrics.SetMetric(metricSet, "net.wan.minLatencyInMilliseconds", latencies[0], metric.GAUGE)
+	metrics.SetMetric(metricSet, "net.wan.medianLatencyInMilliseconds", agent.CalcLatencyMedian(latencies), metric.GAUGE)
+	metrics.SetMetric(metricSet, "net.wan.p99LatencyInMilliseconds", agent.CalcLatencyPercentile(latencies, 0.99), metric.GAUGE)
+
+	return nil
+}
+
+// serverLatencies calculates the latency between every pair of servers of two datacenters
+func serverLatencies(local, other []api.CoordinateEntry) []float64 {
+	latencies := make([]float64, 0, len(local)*len(other))
+	for _, a := range local {
+		if a.Coord == nil {
+			continue
+		}
+
+		for _, b := range other {
+			if b.Coord == nil {
+				continue
+			}
+
+			latencies = append(latencies, agent.CalcLatencyDist(a.Coord, b.Coord))
+		}
+	}
+
+	return latencies
+}
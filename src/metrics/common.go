@@ -1,10 +1,14 @@
 package metrics
 
 import (
+	"github.com/newrelic/infra-integrations-sdk/v3/data/attribute"
 	"github.com/newrelic/infra-integrations-sdk/v3/data/metric"
 	"github.com/newrelic/infra-integrations-sdk/v3/log"
 )
 
+// MetricSetFactory creates a metric set of a sample with its common attributes plus the given ones
+type MetricSetFactory func(attributes ...attribute.Attribute) *metric.Set
+
 // SetMetric is a wrappper around metric.Set.SetMetric with error logging
 func SetMetric(metricSet *metric.Set, name string, value interface{}, sourceType metric.SourceType) {
 	if err := metricSet.SetMetric(name, value, sourceType); err != nil {
@@ -10,6 +10,11 @@ type MetricDefinition struct {
 	APIKey     string
 	MetricName string
 	SourceType metric.SourceType
+	// Labels are the telemetry labels reported as dimensions. Each combination of their values
+	// gets its own metric set with label.<name> attributes, series only differing in other labels
+	// are merged. Series without any of them, and every series if none are given, are merged
+	// into the main metric set.
+	Labels []string
 }
 
 // StatOperation represents a statistical operation for Timer Metrics
@@ -23,8 +28,18 @@ const (
 	// Max represents the max of a Timer metric
 	Max
 
-	// Count represents the count of a Timer metric
+	// Count represents the count of a Timer metric in the telemetry interval
 	Count
+	// Min represents the min of a Timer metric
+	Min
+	// Stddev represents the standard deviation of a Timer metric
+	Stddev
+	// Sum represents the sum of a Timer metric in the telemetry interval
+	Sum
+	// Rate represents the sum of a Timer metric per second
+	Rate
+	// CountPerSecond represents the count of a Timer metric per second
+	CountPerSecond
 )
 
 // TimerDefinition represents a Timer metric and it's statistical
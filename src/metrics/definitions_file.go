@@ -0,0 +1,190 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/data/metric"
+	"gopkg.in/yaml.v3"
+)
+
+// Samples the definitions file can add metrics to
+const (
+	AgentSample      = "agent"
+	DatacenterSample = "datacenter"
+)
+
+// Definitions holds the metric definitions of a sample by the kind of telemetry they read
+type Definitions struct {
+	Gauges   []*MetricDefinition
+	Counters []*MetricDefinition
+	Timers   []*TimerDefinition
+}
+
+// definitionsFile is the layout of the definitions file
+type definitionsFile struct {
+	Definitions []fileDefinition `yaml:"definitions"`
+}
+
+// fileDefinition is a MetricDefinition or TimerDefinition plus the sample and kind of telemetry it targets
+type fileDefinition struct {
+	Sample     string   `yaml:"sample"`
+	Type       string   `yaml:"type"`
+	APIKey     string   `yaml:"api_key"`
+	MetricName string   `yaml:"metric_name"`
+	SourceType string   `yaml:"source_type"`
+	Operation  string   `yaml:"operation"`
+	Labels     []string `yaml:"labels"`
+}
+
+var sourceTypes = map[string]metric.SourceType{
+	"":      metric.GAUGE,
+	"gauge": metric.GAUGE,
+	"rate":  metric.RATE,
+	"delta": metric.DELTA,
+}
+
+var operations = map[string]StatOperation{
+	"average":          Average,
+	"max":              Max,
+	"count":            Count,
+	"min":              Min,
+	"stddev":           Stddev,
+	"sum":              Sum,
+	"rate":             Rate,
+	"count_per_second": CountPerSecond,
+}
+
+// LoadDefinitions reads a definitions file, returning the definitions of each sample
+func LoadDefinitions(path string) (map[string]*Definitions, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading metric definitions file: %s", err.Error())
+	}
+
+	var file definitionsFile
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("error parsing metric definitions file: %s", err.Error())
+	}
+
+	defs := map[string]*Definitions{
+		AgentSample:      {},
+		DatacenterSample: {},
+	}
+	for idx, def := range file.Definitions {
+		if err := def.addTo(defs); err != nil {
+			return nil, fmt.Errorf("bad metric definition %d: %s", idx, err.Error())
+		}
+	}
+
+	return defs, nil
+}
+
+// addTo validates the definition and adds it to the definitions of its sample
+func (fd *fileDefinition) addTo(defs map[string]*Definitions) error {
+	sampleDefs, ok := defs[fd.Sample]
+	if !ok {
+		return fmt.Errorf("unknown sample '%s', must be %s or %s", fd.Sample, AgentSample, DatacenterSample)
+	}
+
+	if fd.APIKey == "" || fd.MetricName == "" {
+		return errors.New("api_key and metric_name are required")
+	}
+
+	sourceType, ok := sourceTypes[fd.SourceType]
+	if !ok {
+		return fmt.Errorf("unknown source_type '%s'", fd.SourceType)
+	}
+
+	def := MetricDefinition{
+		APIKey:     fd.APIKey,
+		MetricName: fd.MetricName,
+		SourceType: sourceType,
+		Labels:     fd.Labels,
+	}
+
+	if fd.Type != "timer" && fd.Operation != "" {
+		return errors.New("only timers have an operation")
+	}
+
+	// counters and timers are already reported per interval, rating them again yields meaningless values
+	if (fd.Type == "counter" || fd.Type == "timer") && sourceType != metric.GAUGE {
+		return fmt.Errorf("source_type '%s' is only supported by gauges", fd.SourceType)
+	}
+
+	switch fd.Type {
+	case "gauge":
+		return sampleDefs.Merge(&Definitions{Gauges: []*MetricDefinition{&def}})
+	case "counter":
+		return sampleDefs.Merge(&Definitions{Counters: []*MetricDefinition{&def}})
+	case "timer":
+		operation, ok := operations[fd.Operation]
+		if !ok {
+			return fmt.Errorf("unknown operation '%s'", fd.Operation)
+		}
+		return sampleDefs.Merge(&Definitions{Timers: []*TimerDefinition{{MetricDefinition: def, Operation: operation}}})
+	default:
+		return fmt.Errorf("unknown type '%s', must be gauge, counter or timer", fd.Type)
+	}
+}
+
+// Merge adds other definitions, failing if any of their metric names is already defined
+func (d *Definitions) Merge(other *Definitions) error {
+	names := d.metricNames()
+	for name := range other.metricNames() {
+		if names[name] {
+			return fmt.Errorf("duplicated metric_name '%s'", name)
+		}
+	}
+
+	d.Gauges = append(d.Gauges, other.Gauges...)
+	d.Counters = append(d.Counters, other.Counters...)
+	d.Timers = append(d.Timers, other.Timers...)
+	return nil
+}
+
+// Extend returns a copy of the definitions merged with user definitions, which can't reuse any of
+// their metric names nor the reserved names the sample reports besides its definitions
+func (d *Definitions) Extend(user *Definitions, reserved []string) (*Definitions, error) {
+	extended := &Definitions{}
+	if err := extended.Merge(d); err != nil {
+		return nil, err
+	}
+
+	if user == nil {
+		return extended, nil
+	}
+
+	names := user.metricNames()
+	for _, name := range reserved {
+		if names[name] {
+			return nil, fmt.Errorf("metric_name '%s' is reserved", name)
+		}
+	}
+
+	if err := extended.Merge(user); err != nil {
+		return nil, err
+	}
+
+	return extended, nil
+}
+
+func (d *Definitions) metricNames() map[string]bool {
+	names := make(map[string]bool)
+	for _, def := range d.Gauges {
+		names[def.MetricName] = true
+	}
+	for _, def := range d.Counters {
+		names[def.MetricName] = true
+	}
+	for _, def := range d.Timers {
+		names[def.MetricName] = true
+	}
+
+	return names
+}
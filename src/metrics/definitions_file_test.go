@@ -0,0 +1,126 @@
+package metrics
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/newrelic/infra-integrations-sdk/v3/data/metric"
+	"github.com/stretchr/testify/require"
+)
+
+func writeDefinitionsFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "consul-definitions.yml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestLoadDefinitions(t *testing.T) {
+	path := writeDefinitionsFile(t, `
+definitions:
+  - sample: agent
+    type: gauge
+    api_key: consul.consul.state.services
+    metric_name: agent.services
+  - sample: agent
+    type: counter
+    api_key: consul.rpc.rate_limit.exceeded
+    metric_name: agent.rpcRateLimitExceeded
+    labels: [op]
+  - sample: datacenter
+    type: timer
+    api_key: consul.raft.apply
+    metric_name: raft.applyMaxInMilliseconds
+    operation: max
+`)
+
+	defs, err := LoadDefinitions(path)
+	require.NoError(t, err)
+
+	require.Equal(t, &Definitions{
+		Gauges: []*MetricDefinition{
+			{APIKey: "consul.consul.state.services", MetricName: "agent.services", SourceType: metric.GAUGE},
+		},
+		Counters: []*MetricDefinition{
+			{APIKey: "consul.rpc.rate_limit.exceeded", MetricName: "agent.rpcRateLimitExceeded", SourceType: metric.GAUGE, Labels: []string{"op"}},
+		},
+	}, defs[AgentSample])
+
+	require.Equal(t, &Definitions{
+		Timers: []*TimerDefinition{
+			{
+				MetricDefinition: MetricDefinition{APIKey: "consul.raft.apply", MetricName: "raft.applyMaxInMilliseconds", SourceType: metric.GAUGE},
+				Operation:        Max,
+			},
+		},
+	}, defs[DatacenterSample])
+}
+
+func TestLoadDefinitions_Empty(t *testing.T) {
+	defs, err := LoadDefinitions(writeDefinitionsFile(t, ""))
+	require.NoError(t, err)
+	require.Equal(t, &Definitions{}, defs[AgentSample])
+	require.Equal(t, &Definitions{}, defs[DatacenterSample])
+}
+
+func TestLoadDefinitions_Errors(t *testing.T) {
+	testCases := []struct {
+		name    string
+		content string
+	}{
+		{"Unknown Sample", "definitions:\n  - {sample: node, type: gauge, api_key: a, metric_name: a}"},
+		{"Unknown Type", "definitions:\n  - {sample: agent, type: histogram, api_key: a, metric_name: a}"},
+		{"Unknown Operation", "definitions:\n  - {sample: agent, type: timer, api_key: a, metric_name: a, operation: p99}"},
+		{"Missing Operation", "definitions:\n  - {sample: agent, type: timer, api_key: a, metric_name: a}"},
+		{"Operation Of Gauge", "definitions:\n  - {sample: agent, type: gauge, api_key: a, metric_name: a, operation: max}"},
+		{"Unknown Source Type", "definitions:\n  - {sample: agent, type: gauge, api_key: a, metric_name: a, source_type: attribute}"},
+		{"Rated Counter", "definitions:\n  - {sample: agent, type: counter, api_key: a, metric_name: a, source_type: rate}"},
+		{"Delta Timer", "definitions:\n  - {sample: agent, type: timer, api_key: a, metric_name: a, operation: count, source_type: delta}"},
+		{"Missing API Key", "definitions:\n  - {sample: agent, type: gauge, metric_name: a}"},
+		{"Missing Metric Name", "definitions:\n  - {sample: agent, type: gauge, api_key: a}"},
+		{"Duplicated Metric Name", "definitions:\n  - {sample: agent, type: gauge, api_key: a, metric_name: a}\n  - {sample: agent, type: counter, api_key: b, metric_name: a}"},
+		{"Unknown Field", "definitions:\n  - {sample: agent, type: gauge, api_key: a, metric_name: a, unit: ms}"},
+		{"Bad YAML", "definitions: ["},
+	}
+
+	for _, tc := range testCases {
+		_, err := LoadDefinitions(writeDefinitionsFile(t, tc.content))
+		require.Error(t, err, tc.name)
+	}
+
+	_, err := LoadDefinitions(filepath.Join(t.TempDir(), "missing.yml"))
+	require.Error(t, err)
+}
+
+func TestDefinitions_Merge(t *testing.T) {
+	defs := &Definitions{Gauges: []*MetricDefinition{{APIKey: "a", MetricName: "a"}}}
+
+	other := &Definitions{Timers: []*TimerDefinition{{MetricDefinition: MetricDefinition{APIKey: "b", MetricName: "b"}}}}
+	require.NoError(t, defs.Merge(other))
+	require.Len(t, defs.Timers, 1)
+
+	require.Error(t, defs.Merge(&Definitions{Counters: []*MetricDefinition{{APIKey: "c", MetricName: "b"}}}))
+	require.Empty(t, defs.Counters)
+}
+
+func TestDefinitions_Extend(t *testing.T) {
+	builtIn := &Definitions{Gauges: []*MetricDefinition{{APIKey: "a", MetricName: "a"}}}
+	reserved := []string{"agent.peers"}
+
+	extended, err := builtIn.Extend(nil, reserved)
+	require.NoError(t, err)
+	require.Equal(t, builtIn, extended)
+
+	user := &Definitions{Gauges: []*MetricDefinition{{APIKey: "b", MetricName: "b"}}}
+	extended, err = builtIn.Extend(user, reserved)
+	require.NoError(t, err)
+	require.Len(t, extended.Gauges, 2)
+	// the built-in definitions are left untouched
+	require.Len(t, builtIn.Gauges, 1)
+
+	_, err = builtIn.Extend(&Definitions{Counters: []*MetricDefinition{{APIKey: "c", MetricName: "a"}}}, reserved)
+	require.Error(t, err)
+
+	_, err = builtIn.Extend(&Definitions{Counters: []*MetricDefinition{{APIKey: "c", MetricName: "agent.peers"}}}, reserved)
+	require.Error(t, err)
+}
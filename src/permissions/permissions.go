@@ -0,0 +1,274 @@
+// Package permissions probes the Consul endpoints needed by each collector so the ones
+// the ACL token can't read are skipped instead of failing on every run
+package permissions
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/newrelic/infra-integrations-sdk/v3/integration"
+	"github.com/newrelic/infra-integrations-sdk/v3/log"
+)
+
+// Collector names
+const (
+	AgentCoreMetrics    = "agent.coreMetrics"
+	AgentPeers          = "agent.peers"
+	AgentLatency        = "agent.latency"
+	AgentInventory      = "agent.inventory"
+	DatacenterCatalog   = "datacenter.catalog"
+	DatacenterHealth    = "datacenter.health"
+	DatacenterRaft      = "datacenter.raft"
+	DatacenterAutopilot = "datacenter.autopilot"
+	DatacenterMembers   = "datacenter.members"
+	DatacenterWAN       = "datacenter.wan"
+)
+
+// InventoryKey is the inventory item listing the skipped collectors
+const InventoryKey = "integration.missingPermissions"
+
+// reasonFiltered is reported for collectors whose queries answered with part of their results filtered out
+const reasonFiltered = "results filtered by ACLs, reporting partial data"
+
+// agentCollectors read the endpoints of the agent they collect, agent:read rules grant them per node
+// so they're probed against every member rather than once
+var agentCollectors = map[string]bool{
+	AgentCoreMetrics: true,
+	AgentInventory:   true,
+}
+
+// matchNothing filters out every result of the list endpoints so their probes stay cheap. The
+// filtered results aren't flagged by the probes, the collectors report them from their own queries.
+const matchNothing = `Node == ""`
+
+var (
+	// staleOptions let any server answer the probes
+	staleOptions = &api.QueryOptions{AllowStale: true}
+	// listOptions keep the probes of the list endpoints from returning the whole catalog
+	listOptions = &api.QueryOptions{AllowStale: true, Filter: matchNothing}
+)
+
+// probe calls the endpoints a collector needs
+type probe func(client *api.Client) error
+
+var probes = map[string]probe{
+	AgentCoreMetrics: func(client *api.Client) error {
+		_, err := client.Agent().Metrics()
+		return err
+	},
+	AgentPeers: func(client *api.Client) error {
+		_, err := client.Status().Peers()
+		return err
+	},
+	AgentLatency: func(client *api.Client) error {
+		_, _, err := client.Coordinate().Nodes(staleOptions)
+		return err
+	},
+	AgentInventory: func(client *api.Client) error {
+		_, err := client.Agent().Self()
+		return err
+	},
+	DatacenterCatalog: func(client *api.Client) error {
+		_, _, err := client.Catalog().Nodes(listOptions)
+		return err
+	},
+	DatacenterHealth: func(client *api.Client) error {
+		if _, _, err := client.Health().State(api.HealthAny, listOptions); err != nil {
+			return err
+		}
+
+		_, _, err := client.Catalog().Services(listOptions)
+		return err
+	},
+	DatacenterRaft: func(client *api.Client) error {
+		_, err := client.Operator().RaftGetConfiguration(staleOptions)
+		return err
+	},
+	DatacenterAutopilot: func(client *api.Client) error {
+		_, err := client.Operator().AutopilotServerHealth(nil)
+		return err
+	},
+	DatacenterMembers: func(client *api.Client) error {
+		_, err := client.Agent().Members(false)
+		return err
+	},
+	DatacenterWAN: func(client *api.Client) error {
+		if _, err := client.Agent().Members(true); err != nil {
+			return err
+		}
+
+		_, err := client.Coordinate().Datacenters()
+		return err
+	},
+}
+
+// probeResult is the outcome of probing a collector, shared by the collectors running concurrently
+type probeResult struct {
+	once   sync.Once
+	denied bool
+}
+
+// results are shared by a Set and the Sets of its members
+type results struct {
+	mu      sync.Mutex
+	probes  map[string]*probeResult
+	missing map[string]string
+	partial map[string]string
+}
+
+// Set probes the endpoints of a collector with the client's token the first time it's about to run,
+// so the collectors an instance never runs, like the datacenter ones on agents that aren't the leader,
+// aren't probed. A nil Set allows every collector.
+type Set struct {
+	client *api.Client
+	// member names the agent the agent collectors are probed against, see Member
+	member  string
+	results *results
+}
+
+// New creates a Set probing with the client's token
+func New(client *api.Client) *Set {
+	return &Set{
+		client: client,
+		results: &results{
+			probes:  make(map[string]*probeResult),
+			missing: make(map[string]string),
+			partial: make(map[string]string),
+		},
+	}
+}
+
+// Member returns a Set probing the agent collectors against the member's client, reported
+// as <member>/<collector>. Every other collector is probed and reported once for the whole Set.
+func (s *Set) Member(name string, client *api.Client) *Set {
+	if s == nil {
+		return nil
+	}
+
+	return &Set{
+		client:  client,
+		member:  name,
+		results: s.results,
+	}
+}
+
+// key names the collector in the results
+func (s *Set) key(collector string) string {
+	if s.member != "" && agentCollectors[collector] {
+		return s.member + "/" + collector
+	}
+
+	return collector
+}
+
+// probe runs the probe of the collector, returning true if the token lacks permissions for it.
+// Only permission errors disable a collector, any other failure is left to the collector.
+func (s *Set) probe(collector, key string) bool {
+	p, ok := probes[collector]
+	if !ok {
+		return false
+	}
+
+	var statusErr api.StatusError
+	if err := p(s.client); !errors.As(err, &statusErr) || statusErr.Code != http.StatusForbidden {
+		return false
+	}
+
+	log.Warn("Skipping collector '%s' for lack of permissions: %s", key, statusErr.Body)
+
+	s.results.mu.Lock()
+	defer s.results.mu.Unlock()
+	s.results.missing[key] = statusErr.Body
+
+	return true
+}
+
+// Allowed returns true if the collector can run, probing it the first time
+func (s *Set) Allowed(collector string) bool {
+	if s == nil {
+		return true
+	}
+
+	key := s.key(collector)
+
+	s.results.mu.Lock()
+	result, ok := s.results.probes[key]
+	if !ok {
+		result = &probeResult{}
+		s.results.probes[key] = result
+	}
+	s.results.mu.Unlock()
+
+	result.once.Do(func() {
+		result.denied = s.probe(collector, key)
+	})
+
+	return !result.denied
+}
+
+// Filtered reports partial data for the collector if the ACLs filtered out part of the results
+// of one of its queries
+func (s *Set) Filtered(collector string, meta *api.QueryMeta) {
+	if s == nil || meta == nil || !meta.ResultsFilteredByACLs {
+		return
+	}
+
+	key := s.key(collector)
+
+	s.results.mu.Lock()
+	defer s.results.mu.Unlock()
+
+	if _, ok := s.results.partial[key]; ok {
+		return
+	}
+
+	log.Warn("Collector '%s' can't read every result: %s", key, reasonFiltered)
+	s.results.partial[key] = reasonFiltered
+}
+
+// Missing returns the skipped collectors, sorted
+func (s *Set) Missing() []string {
+	if s == nil {
+		return nil
+	}
+
+	s.results.mu.Lock()
+	defer s.results.mu.Unlock()
+
+	collectors := make([]string, 0, len(s.results.missing))
+	for collector := range s.results.missing {
+		collectors = append(collectors, collector)
+	}
+	sort.Strings(collectors)
+
+	return collectors
+}
+
+// SetInventory lists the skipped collectors and the ones reporting partial data, with the reason,
+// in the InventoryKey item of the entity. It's called once collection is done as collectors are
+// probed when they're about to run. The collectors are prefixed by the cluster name, if any, since
+// the entity is shared by every cluster.
+func (s *Set) SetInventory(entity *integration.Entity, clusterName string) {
+	if s == nil {
+		return
+	}
+
+	s.results.mu.Lock()
+	defer s.results.mu.Unlock()
+
+	for _, reasons := range []map[string]string{s.results.missing, s.results.partial} {
+		for collector, reason := range reasons {
+			field := collector
+			if clusterName != "" {
+				field = clusterName + "/" + collector
+			}
+
+			if err := entity.SetInventoryItem(InventoryKey, field, reason); err != nil {
+				log.Debug("Error setting Inventory item '%s': %s", InventoryKey, err.Error())
+			}
+		}
+	}
+}
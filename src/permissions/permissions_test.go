@@ -0,0 +1,243 @@
+package permissions
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/newrelic/infra-integrations-sdk/v3/data/inventory"
+	"github.com/newrelic/infra-integrations-sdk/v3/integration"
+	"github.com/newrelic/nri-consul/src/args"
+	"github.com/newrelic/nri-consul/src/testutils"
+)
+
+func setProbeMuxes(mux *http.ServeMux) {
+	denied := func(permission string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintf(w, "Permission denied: token lacks permission '%s'", permission)
+		}
+	}
+
+	mux.HandleFunc("/v1/agent/metrics", denied("agent:read"))
+	mux.HandleFunc("/v1/agent/self", denied("agent:read"))
+	mux.HandleFunc("/v1/operator/raft/configuration", denied("operator:read"))
+	mux.HandleFunc("/v1/operator/autopilot/health", denied("operator:read"))
+
+	// list probes are answered by any server and filter out every result
+	mux.HandleFunc("/v1/catalog/nodes", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.URL.Query()["stale"]; !ok || r.URL.Query().Get("filter") != matchNothing {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `[]`)
+	})
+
+	// failures other than permissions are left to the collectors
+	mux.HandleFunc("/v1/health/state/any", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	mux.HandleFunc("/v1/status/peers", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `["10.0.0.1:8300"]`)
+	})
+	mux.HandleFunc("/v1/coordinate/nodes", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[]`)
+	})
+	mux.HandleFunc("/v1/coordinate/datacenters", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[]`)
+	})
+	mux.HandleFunc("/v1/agent/members", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[]`)
+	})
+}
+
+func createClient(t *testing.T, hostname, port string) *api.Client {
+	arg := args.ArgumentList{
+		Hostname:  hostname,
+		Port:      port,
+		EnableSSL: false,
+		Timeout:   "0s",
+	}
+
+	apiConfig, err := arg.CreateAPIConfig(arg.Hostname)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	client, err := api.NewClient(apiConfig)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	return client
+}
+
+func TestSet_Allowed(t *testing.T) {
+	mux, hostname, port, serverClose := testutils.SetupServer()
+	defer serverClose()
+
+	setProbeMuxes(mux)
+	perms := New(createClient(t, hostname, port))
+
+	denied := []string{AgentCoreMetrics, AgentInventory, DatacenterAutopilot, DatacenterRaft}
+	for _, collector := range denied {
+		if perms.Allowed(collector) {
+			t.Errorf("Expected collector %s not to be allowed", collector)
+		}
+	}
+
+	// other failures don't disable the collector
+	allowed := []string{AgentPeers, AgentLatency, DatacenterCatalog, DatacenterHealth, DatacenterMembers, DatacenterWAN}
+	for _, collector := range allowed {
+		if !perms.Allowed(collector) {
+			t.Errorf("Expected collector %s to be allowed", collector)
+		}
+	}
+
+	want := []string{AgentCoreMetrics, AgentInventory, DatacenterAutopilot, DatacenterRaft}
+	if out := perms.Missing(); !reflect.DeepEqual(out, want) {
+		t.Errorf("Expected %v got %v", want, out)
+	}
+}
+
+func TestSet_ProbesCollectorsAboutToRun(t *testing.T) {
+	mux, hostname, port, serverClose := testutils.SetupServer()
+	defer serverClose()
+
+	requests := 0
+	mux.HandleFunc("/v1/operator/raft/configuration", func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "Permission denied: token lacks permission 'operator:read'")
+	})
+
+	perms := New(createClient(t, hostname, port))
+	if requests != 0 {
+		t.Errorf("Expected no probes before a collector runs, got %d requests", requests)
+	}
+
+	for idx := 0; idx < 2; idx++ {
+		if perms.Allowed(DatacenterRaft) {
+			t.Errorf("Expected collector %s not to be allowed", DatacenterRaft)
+		}
+	}
+
+	if requests != 1 {
+		t.Errorf("Expected the collector to be probed once, got %d requests", requests)
+	}
+}
+
+func TestSet_SetInventory(t *testing.T) {
+	mux, hostname, port, serverClose := testutils.SetupServer()
+	defer serverClose()
+
+	setProbeMuxes(mux)
+	perms := New(createClient(t, hostname, port))
+	for _, collector := range []string{AgentInventory, DatacenterRaft, DatacenterCatalog} {
+		perms.Allowed(collector)
+	}
+	perms.Filtered(DatacenterCatalog, &api.QueryMeta{ResultsFilteredByACLs: true})
+
+	i, err := integration.New("test", "1.0.0")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+
+	entity := i.LocalEntity()
+	perms.SetInventory(entity, "")
+	perms.SetInventory(entity, "east")
+
+	expected := inventory.Items{
+		InventoryKey: {
+			AgentInventory:              "Permission denied: token lacks permission 'agent:read'",
+			DatacenterRaft:              "Permission denied: token lacks permission 'operator:read'",
+			DatacenterCatalog:           reasonFiltered,
+			"east/" + AgentInventory:    "Permission denied: token lacks permission 'agent:read'",
+			"east/" + DatacenterRaft:    "Permission denied: token lacks permission 'operator:read'",
+			"east/" + DatacenterCatalog: reasonFiltered,
+		},
+	}
+
+	if out := entity.Inventory.Items(); !reflect.DeepEqual(out, expected) {
+		t.Errorf("Expected %+v got %+v", expected, out)
+	}
+}
+
+func TestSet_Member(t *testing.T) {
+	mux, hostname, port, serverClose := testutils.SetupServer()
+	defer serverClose()
+
+	setProbeMuxes(mux)
+	perms := New(createClient(t, hostname, port))
+
+	// agent:read is only granted on the second member
+	allowedMux, allowedHostname, allowedPort, allowedServerClose := testutils.SetupServer()
+	defer allowedServerClose()
+
+	peersRequests := 0
+	allowedMux.HandleFunc("/v1/agent/metrics", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{}`)
+	})
+	allowedMux.HandleFunc("/v1/status/peers", func(w http.ResponseWriter, r *http.Request) {
+		peersRequests++
+		fmt.Fprint(w, `[]`)
+	})
+
+	denied := perms.Member("consul-0", createClient(t, hostname, port))
+	allowed := perms.Member("consul-1", createClient(t, allowedHostname, allowedPort))
+
+	if denied.Allowed(AgentCoreMetrics) {
+		t.Errorf("Expected collector %s not to be allowed on consul-0", AgentCoreMetrics)
+	}
+
+	if !allowed.Allowed(AgentCoreMetrics) {
+		t.Errorf("Expected collector %s to be allowed on consul-1", AgentCoreMetrics)
+	}
+
+	// collectors that aren't agent scoped are probed once for every member
+	denied.Allowed(AgentPeers)
+	allowed.Allowed(AgentPeers)
+	if peersRequests != 0 {
+		t.Errorf("Expected %s to be probed once through consul-0, got %d requests on consul-1", AgentPeers, peersRequests)
+	}
+
+	want := []string{"consul-0/" + AgentCoreMetrics}
+	if out := perms.Missing(); !reflect.DeepEqual(out, want) {
+		t.Errorf("Expected %v got %v", want, out)
+	}
+}
+
+func TestSet_Filtered(t *testing.T) {
+	perms := New(nil)
+
+	perms.Filtered(DatacenterCatalog, nil)
+	perms.Filtered(DatacenterCatalog, &api.QueryMeta{})
+	perms.Filtered(DatacenterHealth, &api.QueryMeta{ResultsFilteredByACLs: true})
+	perms.Member("consul-0", nil).Filtered(AgentLatency, &api.QueryMeta{ResultsFilteredByACLs: true})
+
+	want := map[string]string{
+		DatacenterHealth: reasonFiltered,
+		AgentLatency:     reasonFiltered,
+	}
+	if !reflect.DeepEqual(perms.results.partial, want) {
+		t.Errorf("Expected %v got %v", want, perms.results.partial)
+	}
+}
+
+func TestSet_Nil(t *testing.T) {
+	var perms *Set
+
+	if !perms.Allowed(AgentCoreMetrics) {
+		t.Error("Expected a nil Set to allow every collector")
+	}
+
+	if out := perms.Missing(); len(out) != 0 {
+		t.Errorf("Expected no missing collectors got %v", out)
+	}
+
+	perms.Filtered(DatacenterCatalog, &api.QueryMeta{ResultsFilteredByACLs: true})
+	perms.Member("consul-0", nil).SetInventory(nil, "")
+}
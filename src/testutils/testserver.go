@@ -1,6 +1,8 @@
 package testutils
 
 import (
+	"crypto/tls"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -15,3 +17,29 @@ func SetupServer() (mux *http.ServeMux, hostname, port string, teardown func())
 	url, _ := url.Parse(server.URL)
 	return mux, url.Path, url.Port(), server.Close
 }
+
+// SetupTLSServer setups a test HTTPS server with the given TLS config for mocking API Calls
+func SetupTLSServer(tlsConfig *tls.Config) (mux *http.ServeMux, hostname, port string, teardown func()) {
+	mux = http.NewServeMux()
+
+	server := httptest.NewUnstartedServer(mux)
+	server.TLS = tlsConfig
+	server.StartTLS()
+	url, _ := url.Parse(server.URL)
+	return mux, url.Hostname(), url.Port(), server.Close
+}
+
+// SetupUnixServer setups a test HTTP server listening on the unix socket at socketPath for mocking API Calls
+func SetupUnixServer(socketPath string) (mux *http.ServeMux, hostname string, teardown func(), err error) {
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	mux = http.NewServeMux()
+
+	server := httptest.NewUnstartedServer(mux)
+	server.Listener = listener
+	server.Start()
+	return mux, "unix://" + socketPath, server.Close, nil
+}